@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+type Storage interface {
+	ListCoupons(f model.CouponFilter) ([]collector.Record, error)
+	GetCoupon(id string) (collector.Record, error)
+	GetStats(days int) (model.Stats, error)
+	ListChats(onlyActive bool, limit, offset int) ([]model.Chat, error)
+	NewNotification(n model.Notification) (int64, error)
+	ConfirmNotification(id int64) (bool, error)
+	Audit(e model.AuditEntry) error
+}
+
+type Config struct {
+	Logger  log.Logger
+	Storage Storage
+	// Keys maps the API keys to the roles of their holders, the coupons
+	// and the stats need the viewer role, the admin endpoints the admin one.
+	Keys map[string]model.Role
+	// Crawl collects new coupons.
+	Crawl func(ctx context.Context) error
+}
+
+// API serves the coupons, the stats and the admin actions as JSON over HTTP.
+type API struct {
+	cfg *Config
+	// crawling is 1 while a crawl requested through the API runs.
+	crawling int32
+}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+	maxStatDays  = 365
+	// maxBody is the maximum size of a request body.
+	maxBody = 64 << 10
+)
+
+func New(cfg *Config) (*API, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("storage is empty")
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("api keys are empty")
+	}
+	if cfg.Crawl == nil {
+		return nil, errors.New("crawl is empty")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	return &API{cfg: cfg}, nil
+}
+
+// Handler returns the handler of the /api/ paths.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/coupons", a.auth(model.RoleViewer, http.MethodGet, a.coupons))
+	mux.Handle("/api/coupons/", a.auth(model.RoleViewer, http.MethodGet, a.coupon))
+	mux.Handle("/api/stats", a.auth(model.RoleViewer, http.MethodGet, a.stats))
+	mux.Handle("/api/admin/crawl", a.auth(model.RoleAdmin, http.MethodPost, a.crawl))
+	mux.Handle("/api/admin/chats", a.auth(model.RoleAdmin, http.MethodGet, a.chats))
+	mux.Handle("/api/admin/announcements", a.auth(model.RoleAdmin, http.MethodPost, a.announce))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	return mux
+}
+
+// auth checks the method and the API key, the key is passed either as
+// a bearer token or in the X-API-Key header.
+func (a *API) auth(role model.Role, method string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			key = strings.TrimPrefix(h, "Bearer ")
+		}
+		have := a.role(key)
+		if have == model.RoleNone {
+			level.Warn(a.cfg.Logger).Log("msg", "api request with wrong key", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if have < role {
+			level.Warn(a.cfg.Logger).Log("msg", "api request denied", "path", r.URL.Path, "role", have, "remote", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, have)))
+	})
+}
+
+// roleKey is the context key of the role of the key holder.
+type roleKey struct{}
+
+// requestRole returns the role of the key holder of the request.
+func requestRole(r *http.Request) model.Role {
+	role, _ := r.Context().Value(roleKey{}).(model.Role)
+	return role
+}
+
+// role returns the role of the key holder, every key is compared
+// to not leak the matching one through the timing.
+func (a *API) role(key string) model.Role {
+	role := model.RoleNone
+	if key == "" {
+		return role
+	}
+	for k, r := range a.cfg.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			role = r
+		}
+	}
+	return role
+}
+
+func (a *API) coupons(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset, ok := page(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+	f := model.CouponFilter{
+		Source: q.Get("source"),
+		Query:  q.Get("query"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	var err error
+	f.ExpiresAfter, err = parseTime(q.Get("expires_after"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid expires_after")
+		return
+	}
+	f.ExpiresBefore, err = parseTime(q.Get("expires_before"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid expires_before")
+		return
+	}
+	rr, err := a.cfg.Storage.ListCoupons(f)
+	if err != nil {
+		a.fail(w, r, "failed list coupons", err)
+		return
+	}
+	if rr == nil {
+		rr = []collector.Record{}
+	}
+	writeJSON(w, http.StatusOK, rr)
+}
+
+func (a *API) coupon(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/coupons/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	rec, err := a.cfg.Storage.GetCoupon(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "coupon not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, r, "failed get coupon", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+func (a *API) stats(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatDays {
+			writeError(w, http.StatusBadRequest, "invalid days")
+			return
+		}
+		days = n
+	}
+	st, err := a.cfg.Storage.GetStats(days)
+	if err != nil {
+		a.fail(w, r, "failed get stats", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// crawl collects the coupons, a single crawl runs at a time. The crawl is
+// canceled with the request context, it is the server base context as well.
+func (a *API) crawl(w http.ResponseWriter, r *http.Request) {
+	if !atomic.CompareAndSwapInt32(&a.crawling, 0, 1) {
+		writeError(w, http.StatusConflict, "crawl is already running")
+		return
+	}
+	defer atomic.StoreInt32(&a.crawling, 0)
+	level.Info(a.cfg.Logger).Log("msg", "crawl triggered by api", "remote", r.RemoteAddr)
+	a.audit(r, "crawl", "")
+	err := a.cfg.Crawl(r.Context())
+	if err != nil {
+		a.fail(w, r, "failed crawl", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "done"})
+}
+
+func (a *API) chats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset, ok := page(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+	// the deactivated chats are listed with all=true.
+	var all bool
+	if v := q.Get("all"); v != "" {
+		var err error
+		all, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid all")
+			return
+		}
+	}
+	cc, err := a.cfg.Storage.ListChats(!all, limit, offset)
+	if err != nil {
+		a.fail(w, r, "failed list chats", err)
+		return
+	}
+	if cc == nil {
+		cc = []model.Chat{}
+	}
+	writeJSON(w, http.StatusOK, cc)
+}
+
+type announcement struct {
+	Message string `json:"message"`
+	// SendAt is an RFC 3339 time, the announcement is sent now if empty.
+	SendAt string `json:"send_at"`
+}
+
+// announce schedules the announcement to every active chat, unlike the bot
+// command there is no preview to confirm.
+func (a *API) announce(w http.ResponseWriter, r *http.Request) {
+	var req announcement
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeError(w, http.StatusBadRequest, "message is empty")
+		return
+	}
+	sendAt := time.Now()
+	if req.SendAt != "" {
+		sendAt, err = time.Parse(time.RFC3339, req.SendAt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid send_at")
+			return
+		}
+	}
+	id, err := a.cfg.Storage.NewNotification(model.Notification{Message: req.Message, SendAt: sendAt.Unix()})
+	if err != nil {
+		a.fail(w, r, "failed create announcement", err)
+		return
+	}
+	_, err = a.cfg.Storage.ConfirmNotification(id)
+	if err != nil {
+		a.fail(w, r, "failed confirm announcement", err)
+		return
+	}
+	a.audit(r, "announce_confirm", strconv.FormatInt(id, 10))
+	level.Info(a.cfg.Logger).Log("msg", "announcement scheduled by api", "id", id, "sendAt", sendAt, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "send_at": sendAt.Unix()})
+}
+
+func (a *API) fail(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	level.Error(a.cfg.Logger).Log("msg", msg, "path", r.URL.Path, "err", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// audit records the admin action done with the key of the request.
+func (a *API) audit(r *http.Request, action, args string) {
+	err := a.cfg.Storage.Audit(model.AuditEntry{
+		Actor:   "api:" + requestRole(r).String(),
+		Action:  action,
+		Args:    args,
+		Allowed: true,
+	})
+	if err != nil {
+		level.Error(a.cfg.Logger).Log("msg", "failed write audit", "err", err)
+	}
+}
+
+// page parses the limit and the offset, writes the error if they are invalid.
+func page(w http.ResponseWriter, limit, offset string) (int, int, bool) {
+	l, o := defaultLimit, 0
+	var err error
+	if limit != "" {
+		l, err = strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxLimit {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return 0, 0, false
+		}
+	}
+	if offset != "" {
+		o, err = strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset")
+			return 0, 0, false
+		}
+	}
+	return l, o, true
+}
+
+// parseTime parses either a unix time or a 2006-01-02 date, empty is zero.
+func parseTime(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
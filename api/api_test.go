@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/model"
+	"github.com/wenkaler/xfreehack/storage"
+
+	"github.com/go-kit/kit/log"
+)
+
+// fakeStorage records the announcements and the audit, other calls panic.
+type fakeStorage struct {
+	Storage
+	confirmed []int64
+	audit     []model.AuditEntry
+}
+
+func (s *fakeStorage) NewNotification(n model.Notification) (int64, error) {
+	return 7, nil
+}
+
+func (s *fakeStorage) ConfirmNotification(id int64) (bool, error) {
+	s.confirmed = append(s.confirmed, id)
+	return true, nil
+}
+
+func (s *fakeStorage) Audit(e model.AuditEntry) error {
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func TestAnnounceAudited(t *testing.T) {
+	s := &fakeStorage{}
+	a, err := New(&Config{
+		Storage: s,
+		Keys:    map[string]model.Role{"admin-key": model.RoleAdmin, "viewer-key": model.RoleViewer},
+		Crawl:   func(context.Context) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Handler()
+	for key, want := range map[string]int{"viewer-key": http.StatusForbidden, "admin-key": http.StatusCreated} {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/announcements", strings.NewReader(`{"message":"hello"}`))
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", key, w.Code, want)
+		}
+	}
+
+	if len(s.confirmed) != 1 {
+		t.Fatalf("confirmed = %v, want one announcement", s.confirmed)
+	}
+	want := model.AuditEntry{Actor: "api:admin", Action: "announce_confirm", Args: "7", Allowed: true}
+	if len(s.audit) != 1 || s.audit[0] != want {
+		t.Errorf("audit = %+v, want %+v", s.audit, want)
+	}
+}
+
+func TestCrawlAudited(t *testing.T) {
+	s := &fakeStorage{}
+	var crawls int
+	a, err := New(&Config{
+		Storage: s,
+		Keys:    map[string]model.Role{"owner-key": model.RoleOwner},
+		Crawl: func(context.Context) error {
+			crawls++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/crawl", nil)
+	r.Header.Set("Authorization", "Bearer owner-key")
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || crawls != 1 {
+		t.Fatalf("status = %d, crawls = %d, want a crawl", w.Code, crawls)
+	}
+	want := model.AuditEntry{Actor: "api:owner", Action: "crawl", Allowed: true}
+	if len(s.audit) != 1 || s.audit[0] != want {
+		t.Errorf("audit = %+v, want %+v", s.audit, want)
+	}
+}
+
+func TestCouponHidden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.New(filepath.Join(dir, "test.db"), log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 1; i <= 2; i++ {
+		_, err = s.Collect(collector.Record{Code: fmt.Sprintf("CODE%d", i), Link: fmt.Sprintf("https://example.com/%d", i), Date: time.Now().AddDate(0, 0, 7).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second coupon is down-voted.
+	_, err = s.HideRecord("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(&Config{
+		Storage: s,
+		Keys:    map[string]model.Role{"key": model.RoleViewer},
+		Crawl:   func(context.Context) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]int{"1": http.StatusOK, "2": http.StatusNotFound, "3": http.StatusNotFound} {
+		r := httptest.NewRequest(http.MethodGet, "/api/coupons/"+id, nil)
+		r.Header.Set("X-API-Key", "key")
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("coupon %s: status %d, want %d", id, w.Code, want)
+		}
+	}
+}
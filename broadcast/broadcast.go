@@ -0,0 +1,87 @@
+package broadcast
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+type Config struct {
+	Logger  log.Logger
+	Workers int
+}
+
+// Broadcaster runs a delivery to many chats with a pool of workers.
+type Broadcaster struct {
+	cfg *Config
+}
+
+func New(cfg *Config) (*Broadcaster, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	return &Broadcaster{cfg: cfg}, nil
+}
+
+// Summary is the result of a broadcast.
+type Summary struct {
+	Total  int
+	Sent   int
+	Failed int
+	// Skipped chats were not started because the context was done.
+	Skipped int
+	Elapsed time.Duration
+}
+
+// Run calls send for every chat and waits until all of them are done,
+// no new chats are started after the context is done.
+func (b *Broadcaster) Run(ctx context.Context, chats []int64, send func(chatID int64) error) Summary {
+	var (
+		begin = time.Now()
+		sum   = Summary{Total: len(chats)}
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		jobs  = make(chan int64)
+		step  = len(chats)/10 + 1
+	)
+	for i := 0; i < b.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				err := send(id)
+				mu.Lock()
+				if err != nil {
+					sum.Failed++
+					level.Error(b.cfg.Logger).Log("msg", "failed broadcast", "chatID", id, "err", err)
+				} else {
+					sum.Sent++
+				}
+				if done := sum.Sent + sum.Failed; done%step == 0 {
+					level.Info(b.cfg.Logger).Log("msg", "broadcast progress", "done", done, "total", sum.Total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for i, id := range chats {
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			sum.Skipped = len(chats) - i
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	sum.Elapsed = time.Since(begin)
+	level.Info(b.cfg.Logger).Log("msg", "broadcast finished", "total", sum.Total, "sent", sum.Sent, "failed", sum.Failed, "skipped", sum.Skipped, "time elapsed", sum.Elapsed)
+	return sum
+}
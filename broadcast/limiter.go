@@ -0,0 +1,129 @@
+package broadcast
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket refilled with rate tokens per second.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// delay returns the time left until a token is available.
+func (b *bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter limits the global rate of messages and the rate
+// of messages sent to every single chat.
+type Limiter struct {
+	mu      sync.Mutex
+	global  *bucket
+	chats   map[int64]*bucket
+	perChat float64
+	cleaned time.Time
+	// paused is when the messages are allowed again after a flood wait.
+	paused time.Time
+}
+
+// NewLimiter creates a limiter allowing global messages per second in total
+// and perChat messages per second to a single chat, both must be positive.
+func NewLimiter(global, perChat float64) (*Limiter, error) {
+	if !(global > 0) {
+		return nil, fmt.Errorf("global rate must be positive, got %v", global)
+	}
+	if !(perChat > 0) {
+		return nil, fmt.Errorf("chat rate must be positive, got %v", perChat)
+	}
+	now := time.Now()
+	return &Limiter{
+		// a burst below one message would never allow one.
+		global:  newBucket(global, math.Max(global, 1), now),
+		chats:   make(map[int64]*bucket),
+		perChat: perChat,
+		cleaned: now,
+	}, nil
+}
+
+// Wait blocks until a message may be sent to the chat.
+func (l *Limiter) Wait(chatID int64) {
+	for {
+		d := l.reserve(chatID)
+		if d == 0 {
+			return
+		}
+		time.Sleep(d)
+	}
+}
+
+// Pause stops all the messages for the duration,
+// a shorter pause does not end a longer one.
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.paused) {
+		l.paused = until
+	}
+}
+
+func (l *Limiter) reserve(chatID int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Before(l.paused) {
+		return l.paused.Sub(now)
+	}
+	l.clean(now)
+	c, ok := l.chats[chatID]
+	if !ok {
+		c = newBucket(l.perChat, 1, now)
+		l.chats[chatID] = c
+	}
+	l.global.refill(now)
+	c.refill(now)
+	d := l.global.delay()
+	if cd := c.delay(); cd > d {
+		d = cd
+	}
+	if d != 0 {
+		return d
+	}
+	l.global.tokens--
+	c.tokens--
+	return 0
+}
+
+// clean forgets chats which buckets are full again.
+func (l *Limiter) clean(now time.Time) {
+	if now.Sub(l.cleaned) < time.Minute {
+		return
+	}
+	for id, c := range l.chats {
+		c.refill(now)
+		if c.tokens >= c.burst {
+			delete(l.chats, id)
+		}
+	}
+	l.cleaned = now
+}
@@ -0,0 +1,41 @@
+package broadcast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewLimiterRates(t *testing.T) {
+	for _, rates := range [][2]float64{{0, 1}, {-1, 1}, {25, 0}, {25, -1}, {math.NaN(), 1}} {
+		_, err := NewLimiter(rates[0], rates[1])
+		if err == nil {
+			t.Errorf("NewLimiter(%v, %v) succeeded, want an error", rates[0], rates[1])
+		}
+	}
+	l, err := NewLimiter(0.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := l.reserve(1); d != 0 {
+		t.Errorf("first message waits %v with a fractional rate", d)
+	}
+	if d := l.reserve(2); d < time.Second {
+		t.Errorf("second message waits %v, want about 2s", d)
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	l, err := NewLimiter(25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Pause(time.Minute)
+	l.Pause(time.Second)
+	// every chat waits for the longest pause.
+	for _, chatID := range []int64{1, 2} {
+		if d := l.reserve(chatID); d <= 50*time.Second {
+			t.Errorf("chat %d waits %v, want about a minute", chatID, d)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/model"
+	"github.com/wenkaler/xfreehack/outbox"
+	"github.com/wenkaler/xfreehack/snbot"
+	"github.com/wenkaler/xfreehack/storage"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// printer prints the collected records as JSON lines and passes them
+// to the storage, the records are only printed without one.
+type printer struct {
+	enc  *json.Encoder
+	next collector.Storage
+}
+
+func (p *printer) Collect(r collector.Record) (bool, error) {
+	err := p.enc.Encode(r)
+	if err != nil {
+		return false, err
+	}
+	if p.next == nil {
+		return false, nil
+	}
+	return p.next.Collect(r)
+}
+
+func collectCmd(cfg *configure, logger kitlog.Logger, args []string) error {
+	fs := flag.NewFlagSet("collect", flag.ContinueOnError)
+	source := fs.String("source", "", "collect only the source")
+	dryRun := fs.Bool("dry-run", false, "print the records without storing them")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	uris := sources
+	if *source != "" {
+		uri, ok := sources[*source]
+		if !ok {
+			return fmt.Errorf("unknown source %q", *source)
+		}
+		uris = map[string]string{*source: uri}
+	}
+	p := &printer{enc: json.NewEncoder(os.Stdout)}
+	if !*dryRun {
+		s, err := storage.New(cfg.PathDB, logger, nil)
+		if err != nil {
+			return fmt.Errorf("failed create storage: %v", err)
+		}
+		defer s.Close()
+		p.next = s
+	}
+	c, err := collector.New(&collector.Config{
+		Logger:  logger,
+		Storage: p,
+	})
+	if err != nil {
+		return fmt.Errorf("failed create collector: %v", err)
+	}
+	for name, uri := range uris {
+		err = c.Collect(context.Background(), collector.ConditionQuery{URI: uri})
+		if err != nil {
+			return fmt.Errorf("failed collect %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func sendCmd(cfg *configure, logger kitlog.Logger, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	chatID := fs.Int64("chat", 0, "chat id")
+	count := fs.Int64("count", 5, "maximum number of coupons")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *chatID == 0 {
+		return errors.New("chat is required")
+	}
+	s, err := storage.New(cfg.PathDB, logger, nil)
+	if err != nil {
+		return fmt.Errorf("failed create storage: %v", err)
+	}
+	defer s.Close()
+	bc, err := botConfig(cfg, logger, s)
+	if err != nil {
+		return err
+	}
+	bc.SendOnly = true
+	sn, err := snbot.New(bc)
+	if err != nil {
+		return fmt.Errorf("failed create bot: %v", err)
+	}
+	// the coupons go through the outbox as the daily ones, a page with
+	// buttons would expire with this process.
+	err = sn.EnqueueCoupons(*chatID, *count)
+	if err != nil {
+		return fmt.Errorf("failed enqueue coupons: %v", err)
+	}
+	b, err := broadcast.New(&broadcast.Config{
+		Logger:  logger,
+		Workers: cfg.Broadcast.Workers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed create broadcaster: %v", err)
+	}
+	o, err := outbox.New(&outbox.Config{
+		Logger:      logger,
+		Storage:     s,
+		Messenger:   sn,
+		Broadcaster: b,
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+	})
+	if err != nil {
+		return fmt.Errorf("failed create outbox sender: %v", err)
+	}
+	err = o.Flush(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed send coupons: %v", err)
+	}
+	level.Info(logger).Log("msg", "coupons sent", "chatID", *chatID)
+	return nil
+}
+
+func chatsCmd(cfg *configure, logger kitlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected list or deactivate")
+	}
+	s, err := storage.New(cfg.PathDB, logger, nil)
+	if err != nil {
+		return fmt.Errorf("failed create storage: %v", err)
+	}
+	defer s.Close()
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("chats list", flag.ContinueOnError)
+		all := fs.Bool("all", false, "list the deactivated chats as well")
+		limit := fs.Int("limit", -1, "maximum number of chats, -1 is unlimited")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		cc, err := s.ListChats(!*all, *limit, 0)
+		if err != nil {
+			return fmt.Errorf("failed list chats: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTYPE\tNAME\tACTIVE\tCREATED\tREASON")
+		for _, c := range cc {
+			name := c.UserName
+			if name == "" {
+				name = c.FirstName + " " + c.LastName
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\t%s\n", c.ID, c.Type, name, c.Active, formatUnix(c.Created), c.DeactivationReason)
+		}
+		return w.Flush()
+	case "deactivate":
+		fs := flag.NewFlagSet("chats deactivate", flag.ContinueOnError)
+		reason := fs.String("reason", "deactivated by operator", "deactivation reason")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("expected chat id")
+		}
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid chat id: %v", err)
+		}
+		err = s.DeactivateChat(id, *reason)
+		if err != nil {
+			return fmt.Errorf("failed deactivate chat: %v", err)
+		}
+		level.Info(logger).Log("msg", "chat deactivated", "chatID", id)
+		return nil
+	}
+	return fmt.Errorf("unknown chats command %q", args[0])
+}
+
+func dbCmd(cfg *configure, logger kitlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected migrate, backup or stats")
+	}
+	// the migrations are applied on opening.
+	s, err := storage.New(cfg.PathDB, logger, nil)
+	if err != nil {
+		return fmt.Errorf("failed create storage: %v", err)
+	}
+	defer s.Close()
+	switch args[0] {
+	case "migrate":
+		level.Info(logger).Log("msg", "database migrated", "path", cfg.PathDB)
+		return nil
+	case "backup":
+		if len(args) != 2 {
+			return errors.New("expected backup path")
+		}
+		err = s.Backup(args[1])
+		if err != nil {
+			return fmt.Errorf("failed backup database: %v", err)
+		}
+		level.Info(logger).Log("msg", "database backed up", "path", args[1])
+		return nil
+	case "stats":
+		fs := flag.NewFlagSet("db stats", flag.ContinueOnError)
+		days := fs.Int("days", 7, "number of days")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		st, err := s.GetStats(*days)
+		if err != nil {
+			return fmt.Errorf("failed get stats: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	return fmt.Errorf("unknown db command %q", args[0])
+}
+
+func exportCmd(cfg *configure, logger kitlog.Logger, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format: json or csv")
+	all := fs.Bool("all", false, "export the expired coupons as well")
+	source := fs.String("source", "", "export only the source")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	s, err := storage.New(cfg.PathDB, logger, nil)
+	if err != nil {
+		return fmt.Errorf("failed create storage: %v", err)
+	}
+	defer s.Close()
+	rr, err := s.ListCoupons(model.CouponFilter{Source: *source, Expired: *all, Limit: -1})
+	if err != nil {
+		return fmt.Errorf("failed list coupons: %v", err)
+	}
+	sort.Slice(rr, func(i, j int) bool { return rr[i].Date < rr[j].Date })
+	if *format == "json" {
+		if rr == nil {
+			rr = []collector.Record{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rr)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"id", "code", "description", "link", "source", "expires", "created"})
+	for _, r := range rr {
+		w.Write([]string{r.ID, r.Code, r.Description, r.Link, r.Source, formatUnix(r.Date), formatUnix(r.Created)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// formatUnix formats the unix time as a date, zero is empty.
+func formatUnix(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(t, 0).Format("2006-01-02")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// healthcheck probes the running service on the configured HTTP address,
+// it returns the exit code for the Docker HEALTHCHECK.
+func healthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	path := fs.String("path", "/readyz", "probe path, /healthz checks only the process is alive")
+	timeout := fs.Duration("timeout", 5*time.Second, "probe timeout")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	var cfg struct {
+		Listen string `envconfig:"http_listen" default:":8080"`
+	}
+	err = envconfig.Process("", &cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed load configuration:", err)
+		return 1
+	}
+	host, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid http_listen:", err)
+		return 1
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + *path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed probe:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Printf("%d %s", resp.StatusCode, body)
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
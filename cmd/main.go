@@ -1,45 +1,180 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"time"
 
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/model"
 	"github.com/wenkaler/xfreehack/snbot"
-
-	"github.com/jasonlvhit/gocron"
-
 	"github.com/wenkaler/xfreehack/storage"
 
-	"github.com/kelseyhightower/envconfig"
-	"github.com/wenkaler/xfreehack/collector"
-
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/kelseyhightower/envconfig"
 )
 
 type configure struct {
 	ServiceName string `envconfig:"service_name" default:"xFreeService"`
 	PathDB      string `envconfig:"path_db" default:"/db/xfree.db"`
 	TimeToSend  string `envconfig:"time_to_send" default:"18:00"`
-	Telegram    struct {
-		Token      string `envconfig:"telegram_token" required:"true"`
+	// ShutdownTimeout is how long the running work is waited for on exit.
+	ShutdownTimeout time.Duration `envconfig:"shutdown_timeout" default:"20s"`
+	Telegram        struct {
+		// Token is required by the commands talking to Telegram.
+		Token      string `envconfig:"telegram_token"`
 		UpdateTime int    `envconfig:"telegram_update_bot" default:"60"`
+		// UpdateMode is either "polling" or "webhook".
+		UpdateMode string `envconfig:"telegram_update_mode" default:"polling"`
+		// Workers is the number of updates processed concurrently.
+		Workers   int `envconfig:"telegram_workers" default:"4"`
+		QueueSize int `envconfig:"telegram_queue_size" default:"100"`
+		Webhook   struct {
+			URL      string `envconfig:"webhook_url"`
+			Listen   string `envconfig:"webhook_listen" default:":8443"`
+			Secret   string `envconfig:"webhook_secret"`
+			CertFile string `envconfig:"webhook_cert_file"`
+			KeyFile  string `envconfig:"webhook_key_file"`
+		}
+	}
+	Admins    []int64  `envconfig:"admins"`
+	HideScore int      `envconfig:"hide_score" default:"-3"`
+	Channels  channels `envconfig:"channels"`
+	// Templates is a text/template file redefining the coupon templates.
+	Templates   string `envconfig:"templates"`
+	LinkPreview bool   `envconfig:"link_preview" default:"false"`
+	Broadcast   struct {
+		Workers    int     `envconfig:"broadcast_workers" default:"8"`
+		GlobalRate float64 `envconfig:"broadcast_global_rate" default:"25"`
+		ChatRate   float64 `envconfig:"broadcast_chat_rate" default:"1"`
+	}
+	Outbox struct {
+		Interval    time.Duration `envconfig:"outbox_interval" default:"10s"`
+		MaxAttempts int           `envconfig:"outbox_max_attempts" default:"5"`
+	}
+	HTTP struct {
+		Listen  string  `envconfig:"http_listen" default:":8080"`
+		APIKeys apiKeys `envconfig:"api_keys"`
+	}
+	Health struct {
+		// TelegramMaxAge is how long a successful getMe is trusted.
+		TelegramMaxAge time.Duration `envconfig:"health_telegram_max_age" default:"5m"`
+		// CrawlMaxAge is the age of the last successful crawl
+		// after which the service is not ready.
+		CrawlMaxAge time.Duration `envconfig:"health_crawl_max_age" default:"25h"`
+	}
+	Feed struct {
+		Title string `envconfig:"feed_title" default:"xFree coupons"`
+		// URL is the public address of the service.
+		URL   string `envconfig:"feed_url"`
+		Limit int    `envconfig:"feed_limit" default:"100"`
 	}
-	AccessToken string `envconfig:"access_token" required:"true"`
+}
+
+// apiKeys is a comma separated list of API keys with the roles of their
+// holders: key1:viewer,key2:admin. The API is disabled without keys.
+type apiKeys map[string]model.Role
+
+func (k *apiKeys) Decode(value string) error {
+	*k = apiKeys{}
+	for _, kv := range strings.Split(value, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.LastIndex(kv, ":")
+		if i <= 0 {
+			return fmt.Errorf("api key without role")
+		}
+		role, ok := model.ParseRole(kv[i+1:])
+		if !ok {
+			return fmt.Errorf("unknown api key role %q", kv[i+1:])
+		}
+		(*k)[kv[:i]] = role
+	}
+	return nil
+}
+
+// couponsURI is the page the coupons are collected from.
+const couponsURI = "https://lovikod.ru/knigi/promokody-litres"
+
+// sources are the pages the coupons are collected from by the source name.
+var sources = map[string]string{
+	"lovikod.ru": couponsURI,
+}
+
+// channels is a JSON list of channels to post coupons to:
+// [{"id":"@xfree","filter":"(?i)аудио","format":"short"}]
+type channels []snbot.Channel
+
+func (c *channels) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]snbot.Channel)(c))
 }
 
 var serviceVersion = "dev"
 
+const usage = `Usage: xfree [-version] [command] [arguments]
+
+Commands:
+  serve                          run the service, the default command
+  collect [-source x] [-dry-run] collect coupons and print them
+  send -chat ID [-count n]       send the not used coupons to the chat
+  chats list [-all]              list the chats
+  chats deactivate [-reason r] ID
+                                 stop deliveries to the chat
+  db migrate                     apply the database migrations
+  db backup PATH                 copy the database to PATH
+  db stats [-days n]             print the analytics
+  export [-format json|csv] [-all] [-source x]
+                                 print the coupons
+  healthcheck [-path p]          probe the running service
+
+The configuration is read from the environment by every command.
+`
+
+// commands are the operator commands, they share the configuration
+// loading of serve.
+var commands = map[string]func(cfg *configure, logger kitlog.Logger, args []string) error{
+	"collect": collectCmd,
+	"send":    sendCmd,
+	"chats":   chatsCmd,
+	"db":      dbCmd,
+	"export":  exportCmd,
+}
+
 func main() {
-	printVersion := flag.Bool("version", false, "print version and exit")
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches the command line to the command, it returns the exit code.
+func run(arguments []string) int {
+	fs := flag.NewFlagSet("xfree", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+	}
+	printVersion := fs.Bool("version", false, "print version and exit")
+	err := fs.Parse(arguments)
+	if err != nil {
+		return 2
+	}
 	if *printVersion {
 		fmt.Println(serviceVersion)
-		os.Exit(0)
+		return 0
+	}
+	name := fs.Arg(0)
+	if name == "healthcheck" {
+		return healthcheck(fs.Args()[1:])
+	}
+	cmd, ok := commands[name]
+	if !ok && name != "" && name != "serve" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
 	}
 
 	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
@@ -48,68 +183,70 @@ func main() {
 	logger = kitlog.With(logger, "ts", kitlog.DefaultTimestampUTC)
 
 	var cfg configure
-	err := envconfig.Process("", &cfg)
+	err = envconfig.Process("", &cfg)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to load configuration", "err", err)
-		os.Exit(1)
+		return 1
 	}
-	s, err := storage.New(cfg.PathDB, logger)
-	if err != nil {
-		level.Error(logger).Log("msg", "failed create storage", "err", err)
-		os.Exit(1)
+	if !ok {
+		serve(&cfg, logger)
+		return 0
 	}
-
-	sn, err := snbot.New(&snbot.Config{
-		Logger:      logger,
-		Storage:     s,
-		Token:       cfg.Telegram.Token,
-		UpdateTime:  cfg.Telegram.UpdateTime,
-		AccessToken: cfg.AccessToken,
-	})
-	if err != nil {
-		level.Error(logger).Log("msg", "failed create bot", "err", err)
-		os.Exit(1)
+	var args []string
+	if fs.NArg() > 1 {
+		args = fs.Args()[1:]
+	}
+	err = cmd(&cfg, logger, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
 	}
-
-	c, err := collector.New(&collector.Config{
-		Logger:  logger,
-		Storage: s,
-	})
 	if err != nil {
-		level.Error(logger).Log("msg", "failed create collector", "err", err)
-		os.Exit(1)
-	}
-	go sn.Run()
-	c.Collect(collector.ConditionQuery{
-		URI: "https://lovikod.ru/knigi/promokody-litres",
-	})
-	gocron.Every(1).Days().At(cfg.TimeToSend).Do(task, sn, s, c, logger)
-	cronCh := gocron.Start()
-
-	cl := make(chan os.Signal, 1)
-	signal.Notify(cl, syscall.SIGTERM, syscall.SIGINT)
-	sig := <-cl
-	cronCh <- true
-	level.Info(logger).Log("msg", "received signal, exiting", "signal", sig)
-	s.Close()
-
-	level.Info(logger).Log("msg", "goodbye")
+		level.Error(logger).Log("msg", "command failed", "command", name, "err", err)
+		return 1
+	}
+	return 0
 }
 
-func task(bot *snbot.SNBot, s *storage.Storage, c *collector.Collector, logger kitlog.Logger) {
-	c.Collect(collector.ConditionQuery{
-		URI: "https://lovikod.ru/knigi/promokody-litres",
-	})
-	chats, err := s.GetChat()
-	if err != nil {
-		level.Error(logger).Log("msg", "failed get chats", "err", err)
+// botConfig returns the bot configuration, the caller sets the metrics
+// or the send only mode.
+func botConfig(cfg *configure, logger kitlog.Logger, s *storage.Storage) (*snbot.Config, error) {
+	if cfg.Telegram.Token == "" {
+		return nil, errors.New("telegram_token is required")
 	}
-	for _, id := range chats {
-		err := bot.SendCoupons(id, "", snbot.Daily)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed send coupons", "chatID", id, "err", err)
-			continue
+	var webhook *snbot.Webhook
+	switch cfg.Telegram.UpdateMode {
+	case "polling":
+	case "webhook":
+		if cfg.Telegram.Webhook.URL == "" {
+			return nil, errors.New("webhook url is required in webhook mode")
+		}
+		webhook = &snbot.Webhook{
+			URL:      cfg.Telegram.Webhook.URL,
+			Listen:   cfg.Telegram.Webhook.Listen,
+			Secret:   cfg.Telegram.Webhook.Secret,
+			CertFile: cfg.Telegram.Webhook.CertFile,
+			KeyFile:  cfg.Telegram.Webhook.KeyFile,
 		}
+	default:
+		return nil, fmt.Errorf("unknown update mode %q", cfg.Telegram.UpdateMode)
 	}
-	level.Info(logger).Log("msg", "send all chats new coupons")
+	limiter, err := broadcast.NewLimiter(cfg.Broadcast.GlobalRate, cfg.Broadcast.ChatRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broadcast rate: %v", err)
+	}
+	return &snbot.Config{
+		Logger:      logger,
+		Storage:     s,
+		Token:       cfg.Telegram.Token,
+		UpdateTime:  cfg.Telegram.UpdateTime,
+		Admins:      cfg.Admins,
+		HideScore:   cfg.HideScore,
+		Channels:    cfg.Channels,
+		Limiter:     limiter,
+		Webhook:     webhook,
+		Workers:     cfg.Telegram.Workers,
+		QueueSize:   cfg.Telegram.QueueSize,
+		Templates:   cfg.Templates,
+		LinkPreview: cfg.LinkPreview,
+	}, nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wenkaler/xfreehack/model"
+	"github.com/wenkaler/xfreehack/storage"
+
+	"github.com/go-kit/kit/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// setEnv sets the environment variable for the test.
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// testEnv points the commands to a database in a temporary directory
+// and drops their output, it returns the directory.
+func testEnv(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "xfree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	setEnv(t, "PATH_DB", filepath.Join(dir, "test.db"))
+	setEnv(t, "TELEGRAM_TOKEN", "")
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = null, null
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		null.Close()
+	})
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	dir := testEnv(t)
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-version"}, 0},
+		{[]string{"-unknown"}, 2},
+		{[]string{"unknown"}, 2},
+		{[]string{"healthcheck", "-unknown"}, 2},
+		{[]string{"db", "migrate"}, 0},
+		{[]string{"db"}, 1},
+		{[]string{"db", "unknown"}, 1},
+		{[]string{"db", "stats", "-days", "1"}, 0},
+		{[]string{"db", "stats", "-days"}, 1},
+		{[]string{"db", "backup"}, 1},
+		{[]string{"db", "backup", filepath.Join(dir, "backup.db")}, 0},
+		{[]string{"chats", "list", "-all", "-limit", "10"}, 0},
+		{[]string{"chats"}, 1},
+		{[]string{"chats", "unknown"}, 1},
+		{[]string{"chats", "deactivate"}, 1},
+		{[]string{"chats", "deactivate", "chat"}, 1},
+		{[]string{"export"}, 0},
+		{[]string{"export", "-format", "csv", "-all"}, 0},
+		{[]string{"export", "-format", "xml"}, 1},
+		{[]string{"collect", "-source", "unknown"}, 1},
+		{[]string{"send"}, 1},
+		{[]string{"send", "-h"}, 2},
+		// the token is required to send.
+		{[]string{"send", "-chat", "1"}, 1},
+	} {
+		if code := run(tt.args); code != tt.code {
+			t.Errorf("run(%q) = %d, want %d", tt.args, code, tt.code)
+		}
+	}
+	_, err := os.Stat(filepath.Join(dir, "backup.db"))
+	if err != nil {
+		t.Errorf("backup: %v", err)
+	}
+}
+
+func TestChatsDeactivate(t *testing.T) {
+	dir := testEnv(t)
+	s, err := storage.New(filepath.Join(dir, "test.db"), log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.NewChat(&tgbotapi.Chat{ID: 42, Type: "private"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := run([]string{"chats", "deactivate", "-reason", "spam", "42"}); code != 0 {
+		t.Fatalf("exit code %d, want 0", code)
+	}
+	cc, err := s.ListChats(false, -1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc) != 1 || cc[0].Active || cc[0].DeactivationReason != "spam" {
+		t.Errorf("chats = %+v, want the chat deactivated for spam", cc)
+	}
+}
+
+func TestAPIKeysDecode(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  apiKeys
+		err   bool
+	}{
+		{value: "", want: apiKeys{}},
+		{value: "k1:viewer, k2:admin,", want: apiKeys{"k1": model.RoleViewer, "k2": model.RoleAdmin}},
+		// the role follows the last colon.
+		{value: "a:b:owner", want: apiKeys{"a:b": model.RoleOwner}},
+		{value: "k1", err: true},
+		{value: ":admin", err: true},
+		{value: "k1:none", err: true},
+	} {
+		var k apiKeys
+		err := k.Decode(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("Decode(%q) error = %v", tt.value, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if len(k) != len(tt.want) {
+			t.Errorf("Decode(%q) = %v, want %v", tt.value, k, tt.want)
+			continue
+		}
+		for key, role := range tt.want {
+			if k[key] != role {
+				t.Errorf("Decode(%q) = %v, want %v", tt.value, k, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestChannelsDecode(t *testing.T) {
+	var c channels
+	err := c.Decode(`[{"id":"@xfree","filter":"(?i)аудио","format":"short"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 1 || c[0].ID != "@xfree" || !strings.Contains(c[0].Filter, "аудио") {
+		t.Errorf("channels = %+v", c)
+	}
+	err = c.Decode(`{"id":"@xfree"}`)
+	if err == nil {
+		t.Error("a single object is decoded")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/wenkaler/xfreehack/api"
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/feed"
+	"github.com/wenkaler/xfreehack/health"
+	"github.com/wenkaler/xfreehack/lifecycle"
+	"github.com/wenkaler/xfreehack/outbox"
+	"github.com/wenkaler/xfreehack/prom"
+	"github.com/wenkaler/xfreehack/snbot"
+	"github.com/wenkaler/xfreehack/storage"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/jasonlvhit/gocron"
+)
+
+// httpShutdownTimeout is how long the HTTP server waits for the running requests.
+const httpShutdownTimeout = 10 * time.Second
+
+// dailyCoupons is the number of coupons sent to every chat a day.
+const dailyCoupons = 5
+
+// serve runs the bot, the outbox, the HTTP server and the scheduler
+// until SIGTERM or SIGINT.
+func serve(cfg *configure, logger kitlog.Logger) {
+	reg := prom.NewRegistry()
+	s, err := storage.New(cfg.PathDB, logger, reg.NewHistogram("xfree_db_query_duration_seconds", "Duration of SQLite queries by statement kind.", prom.DefBuckets))
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create storage", "err", err)
+		os.Exit(1)
+	}
+
+	bc, err := botConfig(cfg, logger, s)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed configure bot", "err", err)
+		os.Exit(1)
+	}
+	bc.Sent = reg.NewCounter("xfree_messages_sent_total", "Messages sent to Telegram.")
+	bc.Failed = reg.NewCounter("xfree_messages_failed_total", "Messages failed after retries by error kind.")
+	bc.UpdateDuration = reg.NewHistogram("xfree_update_duration_seconds", "Duration of handling updates by type.", prom.DefBuckets)
+	sn, err := snbot.New(bc)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create bot", "err", err)
+		os.Exit(1)
+	}
+
+	c, err := collector.New(&collector.Config{
+		Logger:        logger,
+		Storage:       s,
+		Crawls:        reg.NewCounter("xfree_crawls_total", "Crawls by source and result."),
+		CrawlDuration: reg.NewHistogram("xfree_crawl_duration_seconds", "Duration of crawls by source and result.", []float64{.5, 1, 2.5, 5, 10, 30, 60}),
+		Inserted:      reg.NewCounter("xfree_records_inserted_total", "New records by source."),
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create collector", "err", err)
+		os.Exit(1)
+	}
+	b, err := broadcast.New(&broadcast.Config{
+		Logger:  logger,
+		Workers: cfg.Broadcast.Workers,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create broadcaster", "err", err)
+		os.Exit(1)
+	}
+	o, err := outbox.New(&outbox.Config{
+		Logger:      logger,
+		Storage:     s,
+		Messenger:   sn,
+		Broadcaster: b,
+		Interval:    cfg.Outbox.Interval,
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create outbox sender", "err", err)
+		os.Exit(1)
+	}
+	reg.NewGaugeFunc("xfree_active_chats", "Active chats.", func() (float64, error) {
+		n, err := s.GetCountUser()
+		return float64(n), err
+	})
+	reg.NewGaugeFunc("xfree_outbox_pending", "Undelivered messages in the outbox.", func() (float64, error) {
+		n, err := s.CountPending()
+		return float64(n), err
+	})
+	reg.NewGaugeFunc("xfree_updates_queued", "Updates waiting in the worker queues.", func() (float64, error) {
+		return float64(sn.DispatchStats().Queued), nil
+	})
+	reg.NewCounterFunc("xfree_updates_processed_total", "Updates handled by the workers.", func() (float64, error) {
+		return float64(sn.DispatchStats().Processed), nil
+	})
+	reg.NewCounterFunc("xfree_updates_blocked_total", "Updates which waited for a full worker queue.", func() (float64, error) {
+		return float64(sn.DispatchStats().Blocked), nil
+	})
+	reg.NewCounterFunc("xfree_updates_blocked_seconds_total", "Time spent waiting for full worker queues.", func() (float64, error) {
+		return sn.DispatchStats().BlockedTime.Seconds(), nil
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	h, err := health.New(&health.Config{
+		Logger: logger,
+		Checks: map[string]health.Check{
+			"db": s.Ping,
+			"telegram": func(context.Context) error {
+				return sn.CheckTelegram(cfg.Health.TelegramMaxAge)
+			},
+			"crawl": health.Recent(c.LastSuccess, cfg.Health.CrawlMaxAge),
+		},
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create health checks", "err", err)
+		os.Exit(1)
+	}
+	hh := h.Handler()
+	mux.Handle("/healthz", hh)
+	mux.Handle("/readyz", hh)
+	if len(cfg.HTTP.APIKeys) != 0 {
+		a, err := api.New(&api.Config{
+			Logger:  logger,
+			Storage: s,
+			Keys:    cfg.HTTP.APIKeys,
+			Crawl: func(ctx context.Context) error {
+				return c.Collect(ctx, collector.ConditionQuery{URI: couponsURI})
+			},
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "failed create api", "err", err)
+			os.Exit(1)
+		}
+		mux.Handle("/api/", a.Handler())
+	}
+	f, err := feed.New(&feed.Config{
+		Logger:  logger,
+		Storage: s,
+		Title:   cfg.Feed.Title,
+		URL:     cfg.Feed.URL,
+		Limit:   cfg.Feed.Limit,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create feed", "err", err)
+		os.Exit(1)
+	}
+	mux.Handle("/feed/", f.Handler())
+	lc, err := lifecycle.New(&lifecycle.Config{
+		Logger:  logger,
+		Timeout: cfg.ShutdownTimeout,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed create lifecycle manager", "err", err)
+		os.Exit(1)
+	}
+	lc.Go("bot", sn.Run)
+	lc.Go("outbox", o.Run)
+	lc.Go("http", func(ctx context.Context) error {
+		return serveHTTP(ctx, &http.Server{Addr: cfg.HTTP.Listen, Handler: mux}, logger)
+	})
+	lc.Go("scheduler", func(ctx context.Context) error {
+		err := c.Collect(ctx, collector.ConditionQuery{URI: couponsURI})
+		if err != nil {
+			level.Error(logger).Log("msg", "failed collect", "err", err)
+		}
+		gocron.Every(1).Days().At(cfg.TimeToSend).Do(task, ctx, sn, s, c, logger)
+		// jobs run on the ticker goroutine, none is running once it returns.
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				gocron.RunPending()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	err = lc.Wait(syscall.SIGTERM, syscall.SIGINT)
+	if err != nil {
+		// storage is left open, a component may still be writing.
+		level.Error(logger).Log("msg", "service stopped", "err", err)
+		os.Exit(1)
+	}
+	s.Close()
+
+	level.Info(logger).Log("msg", "goodbye")
+}
+
+func task(ctx context.Context, bot *snbot.SNBot, s *storage.Storage, c *collector.Collector, logger kitlog.Logger) {
+	err := c.Collect(ctx, collector.ConditionQuery{URI: couponsURI})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed collect", "err", err)
+	}
+	err = bot.Publish()
+	if err != nil {
+		level.Error(logger).Log("msg", "failed publish coupons", "err", err)
+	}
+	chats, err := s.GetChat()
+	if err != nil {
+		level.Error(logger).Log("msg", "failed get chats", "err", err)
+	}
+	for _, id := range chats {
+		if ctx.Err() != nil {
+			return
+		}
+		err := bot.EnqueueCoupons(id, dailyCoupons)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed enqueue coupons", "chatID", id, "err", err)
+			continue
+		}
+	}
+	level.Info(logger).Log("msg", "enqueue new coupons for all chats")
+}
+
+// serveHTTP serves the API until the context is done, the requests
+// get the context as well to stop the long ones.
+func serveHTTP(ctx context.Context, srv *http.Server, logger kitlog.Logger) error {
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	errc := make(chan error, 1)
+	go func() {
+		level.Info(logger).Log("msg", "http server started", "listen", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed serve http: %v", err)
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(sctx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed shutdown http server: %v", err)
+	}
+	return nil
+}
@@ -1,17 +1,22 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 )
 
 var month = map[string]string{"январь": "January", "февраль": "February", "март": "March", "апрель": "April", "май": "May", "июнь": "June", "июль": "July", "август": "August", "сентябрь": "September", "октябрь": "October", "ноябрь": "November", "декабрь": "December"}
@@ -19,14 +24,22 @@ var month = map[string]string{"январь": "January", "февраль": "Febr
 type Config struct {
 	Logger  log.Logger
 	Storage Storage
+	// Crawls counts the crawls by source and result,
+	// CrawlDuration observes their duration in seconds.
+	Crawls        metrics.Counter
+	CrawlDuration metrics.Histogram
+	// Inserted counts the new records by source.
+	Inserted metrics.Counter
 }
 
 type Storage interface {
-	Collect(records Record) error
+	Collect(records Record) (bool, error)
 }
 
 type Collector struct {
-	cfg *Config
+	// lastSuccess is the unix time in nanoseconds of the last successful crawl.
+	lastSuccess int64
+	cfg         *Config
 }
 
 const (
@@ -42,6 +55,15 @@ func New(cfg *Config) (*Collector, error) {
 	if cfg.Logger == nil {
 		cfg.Logger = log.NewNopLogger()
 	}
+	if cfg.Crawls == nil {
+		cfg.Crawls = discard.NewCounter()
+	}
+	if cfg.CrawlDuration == nil {
+		cfg.CrawlDuration = discard.NewHistogram()
+	}
+	if cfg.Inserted == nil {
+		cfg.Inserted = discard.NewCounter()
+	}
 	collector := &Collector{
 		cfg: cfg,
 	}
@@ -50,12 +72,15 @@ func New(cfg *Config) (*Collector, error) {
 }
 
 type Record struct {
-	ID          string `db:"id"`
-	Code        string `db:"code"`
-	Date        int64  `db:"date"`
-	Link        string `db:"link"`
-	PostID      string `db:"post_id"`
-	Description string `db:"description"`
+	ID          string `db:"id" json:"id"`
+	Code        string `db:"code" json:"code"`
+	Date        int64  `db:"date" json:"date"`
+	Link        string `db:"link" json:"link"`
+	PostID      string `db:"post_id" json:"-"`
+	Description string `db:"description" json:"description"`
+	Source      string `db:"source" json:"source"`
+	// Created is when the record was collected, zero for old records.
+	Created int64 `db:"created" json:"created"`
 }
 
 var (
@@ -63,7 +88,7 @@ var (
 	reMonth, _ = regexp.Compile(`([аА-яЯ]{3,8})\s([0-9]{4})`)
 )
 
-func (c *Collector) collect(d *goquery.Document) {
+func (c *Collector) collect(d *goquery.Document, source string) {
 	if d == nil {
 		level.Error(c.cfg.Logger).Log("msg", "document was nil")
 		return
@@ -72,6 +97,7 @@ func (c *Collector) collect(d *goquery.Document) {
 		if i == 0 {
 			selection.Find("tr").Each(func(i int, selection *goquery.Selection) {
 				var r Record
+				r.Source = source
 				selection.Find("td").Each(func(column int, s *goquery.Selection) {
 					switch column {
 					case date:
@@ -106,23 +132,56 @@ func (c *Collector) collect(d *goquery.Document) {
 						r.Description = s.Text()
 					}
 				})
-				err := c.cfg.Storage.Collect(r)
+				inserted, err := c.cfg.Storage.Collect(r)
 				if err != nil {
 					level.Error(c.cfg.Logger).Log("msg", "failed create record", "err", err)
 				}
+				if inserted {
+					c.cfg.Inserted.With("source", source).Add(1)
+				}
 			})
 		}
 	})
 }
 
+// LastSuccess returns the time of the last successful crawl, zero if none.
+func (c *Collector) LastSuccess() time.Time {
+	n := atomic.LoadInt64(&c.lastSuccess)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
 type ConditionQuery struct {
 	URI string
 }
 
-func (c *Collector) Collect(cq ConditionQuery) error {
+// Collect loads the page and stores the coupons found on it,
+// the request is cancelled with the context.
+func (c *Collector) Collect(ctx context.Context, cq ConditionQuery) (err error) {
 	begin := time.Now()
+	var source string
+	if u, err := url.Parse(cq.URI); err == nil {
+		source = u.Host
+	}
+	defer func() {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		if err == nil {
+			atomic.StoreInt64(&c.lastSuccess, time.Now().UnixNano())
+		}
+		c.cfg.Crawls.With("source", source, "result", result).Add(1)
+		c.cfg.CrawlDuration.With("source", source, "result", result).Observe(time.Since(begin).Seconds())
+	}()
 	level.Info(c.cfg.Logger).Log("msg", "collect", "url", cq.URI)
-	resp, err := http.Get(cq.URI)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cq.URI, nil)
+	if err != nil {
+		return fmt.Errorf("failed create request url: %s, reason: %v", cq.URI, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed get request url: %s, reason: %v", cq.URI, err)
 
@@ -137,7 +196,7 @@ func (c *Collector) Collect(cq ConditionQuery) error {
 		return fmt.Errorf("failed create newDocument: %v", err)
 	}
 
-	c.collect(doc)
+	c.collect(doc, source)
 	level.Info(c.cfg.Logger).Log("msg", "collect records was finished", "time elapsed", time.Since(begin))
 	return nil
 }
@@ -0,0 +1,285 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+type Storage interface {
+	ListCoupons(f model.CouponFilter) ([]collector.Record, error)
+}
+
+type Config struct {
+	Logger  log.Logger
+	Storage Storage
+	// Title of the feeds.
+	Title string
+	// URL is the public address of the service the feed links are built
+	// on, the request host is used if empty.
+	URL string
+	// Limit is the maximum number of entries in a feed.
+	Limit int
+}
+
+// Feed publishes the active coupons as Atom and JSON Feed documents.
+type Feed struct {
+	cfg *Config
+}
+
+// idPrefix makes the entry ids, the record id follows it.
+const idPrefix = "urn:xfreehack:coupon:"
+
+// feedPrefix makes the feed ids, the feed path and its filter follow it.
+const feedPrefix = "urn:xfreehack:feed:"
+
+func New(cfg *Config) (*Feed, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("storage is empty")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	if cfg.Title == "" {
+		cfg.Title = "xFree coupons"
+	}
+	if cfg.Limit == 0 {
+		cfg.Limit = 100
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &Feed{cfg: cfg}, nil
+}
+
+// Handler returns the handler of /feed/atom and /feed/json, both accept
+// the source and query parameters to filter the coupons.
+func (f *Feed) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed/atom", f.serve(f.atom))
+	mux.HandleFunc("/feed/json", f.serve(f.json))
+	return mux
+}
+
+// entry is a coupon in a feed.
+type entry struct {
+	rec     collector.Record
+	id      string
+	title   string
+	summary string
+	updated time.Time
+	expires time.Time
+}
+
+func newEntry(rec collector.Record) entry {
+	e := entry{
+		rec:     rec,
+		id:      idPrefix + rec.ID,
+		title:   rec.Description,
+		expires: time.Unix(rec.Date, 0),
+		// records collected before the creation time was stored have
+		// only the expiry time.
+		updated: time.Unix(rec.Date, 0),
+	}
+	if rec.Created != 0 {
+		e.updated = time.Unix(rec.Created, 0)
+	}
+	if e.title == "" {
+		e.title = rec.Code
+	}
+	e.summary = fmt.Sprintf("%s: %s\n%s: %s",
+		locale.T(locale.Default, "label.code"), rec.Code,
+		locale.T(locale.Default, "label.expires"), e.expires.Format("02.01.2006"))
+	return e
+}
+
+type render func(w http.ResponseWriter, id, self string, updated time.Time, ee []entry) error
+
+func (f *Feed) serve(render render) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		filter := url.Values{}
+		for _, k := range []string{"source", "query"} {
+			if v := q.Get(k); v != "" {
+				filter.Set(k, v)
+			}
+		}
+		rr, err := f.cfg.Storage.ListCoupons(model.CouponFilter{
+			Source: filter.Get("source"),
+			Query:  filter.Get("query"),
+			Limit:  f.cfg.Limit,
+		})
+		if err != nil {
+			level.Error(f.cfg.Logger).Log("msg", "failed list coupons", "path", r.URL.Path, "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// the feed is as old as its newest entry, an empty one is not
+		// changed since the start of the epoch.
+		updated := time.Unix(0, 0)
+		ee := make([]entry, 0, len(rr))
+		for _, rec := range rr {
+			e := newEntry(rec)
+			if e.updated.After(updated) {
+				updated = e.updated
+			}
+			ee = append(ee, e)
+		}
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+		// the id does not change with the order or the unknown parameters.
+		id := feedPrefix + strings.TrimPrefix(r.URL.Path, "/")
+		if len(filter) != 0 {
+			id += "?" + filter.Encode()
+		}
+		err = render(w, id, f.self(r), updated, ee)
+		if err != nil {
+			level.Error(f.cfg.Logger).Log("msg", "failed write feed", "path", r.URL.Path, "err", err)
+		}
+	}
+}
+
+// self returns the address of the requested feed.
+func (f *Feed) self(r *http.Request) string {
+	base := f.cfg.URL
+	if base == "" {
+		base = "http://" + r.Host
+	}
+	u := base + r.URL.Path
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	return u
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    []atomLink  `xml:"link"`
+	Entry   []atomEntry `xml:"entry"`
+}
+
+// atomAuthor is required by RFC 4287 when the entries have no author.
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Link    []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+	// Category holds the source of the coupon.
+	Category *atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (f *Feed) atom(w http.ResponseWriter, id, self string, updated time.Time, ee []entry) error {
+	af := atomFeed{
+		ID:      id,
+		Title:   f.cfg.Title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: f.cfg.Title},
+		Link:    []atomLink{{Href: self, Rel: "self"}},
+	}
+	for _, e := range ee {
+		ae := atomEntry{
+			ID:      e.id,
+			Title:   e.title,
+			Updated: e.updated.UTC().Format(time.RFC3339),
+			Summary: e.summary,
+		}
+		if e.rec.Link != "" {
+			ae.Link = []atomLink{{Href: e.rec.Link, Rel: "alternate"}}
+		}
+		if e.rec.Source != "" {
+			ae.Category = &atomCategory{Term: e.rec.Source}
+		}
+		af.Entry = append(af.Entry, ae)
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, err := w.Write([]byte(xml.Header))
+	if err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(af)
+}
+
+// jsonFeed is a JSON Feed 1.1 document.
+type jsonFeed struct {
+	Version string     `json:"version"`
+	Title   string     `json:"title"`
+	FeedURL string     `json:"feed_url"`
+	Items   []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url,omitempty"`
+	Title       string   `json:"title"`
+	ContentText string   `json:"content_text"`
+	Modified    string   `json:"date_modified"`
+	Tags        []string `json:"tags,omitempty"`
+	Coupon      coupon   `json:"_coupon"`
+}
+
+// coupon is the JSON Feed extension with the coupon details.
+type coupon struct {
+	Code    string `json:"code"`
+	Expires string `json:"expires"`
+	Source  string `json:"source,omitempty"`
+}
+
+func (f *Feed) json(w http.ResponseWriter, id, self string, updated time.Time, ee []entry) error {
+	jf := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   f.cfg.Title,
+		FeedURL: self,
+		Items:   []jsonItem{},
+	}
+	for _, e := range ee {
+		it := jsonItem{
+			ID:          e.id,
+			URL:         e.rec.Link,
+			Title:       e.title,
+			ContentText: e.summary,
+			Modified:    e.updated.UTC().Format(time.RFC3339),
+			Coupon: coupon{
+				Code:    e.rec.Code,
+				Expires: e.expires.UTC().Format(time.RFC3339),
+				Source:  e.rec.Source,
+			},
+		}
+		if e.rec.Source != "" {
+			it.Tags = []string{e.rec.Source}
+		}
+		jf.Items = append(jf.Items, it)
+	}
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	return json.NewEncoder(w).Encode(jf)
+}
@@ -0,0 +1,160 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/model"
+)
+
+// fakeStorage returns the records and keeps the last filter.
+type fakeStorage struct {
+	records []collector.Record
+	filter  model.CouponFilter
+}
+
+func (s *fakeStorage) ListCoupons(f model.CouponFilter) ([]collector.Record, error) {
+	s.filter = f
+	return s.records, nil
+}
+
+var (
+	created = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	expires = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newTestFeed(t *testing.T) (http.Handler, *fakeStorage) {
+	s := &fakeStorage{records: []collector.Record{
+		{ID: "1", Code: "CODE1", Description: "first", Link: "https://example.com/1", Source: "lovikod.ru", Date: expires.Unix(), Created: created.Unix()},
+		// the title falls back to the code.
+		{ID: "2", Code: "CODE2", Date: expires.Unix()},
+	}}
+	f, err := New(&Config{Storage: s, URL: "https://xfree.example.com/", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Handler(), s
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestAtom(t *testing.T) {
+	h, _ := newTestFeed(t)
+	w := get(h, "/feed/atom")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	// the second record has only the expiry time.
+	if lm := w.Header().Get("Last-Modified"); lm != expires.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", lm, expires.Format(http.TimeFormat))
+	}
+	var af atomFeed
+	err := xml.Unmarshal(w.Body.Bytes(), &af)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if af.ID != "urn:xfreehack:feed:feed/atom" || af.Author.Name == "" || af.Updated != expires.Format(time.RFC3339) {
+		t.Errorf("feed id %q, author %q, updated %q", af.ID, af.Author.Name, af.Updated)
+	}
+	if len(af.Link) != 1 || af.Link[0].Href != "https://xfree.example.com/feed/atom" || af.Link[0].Rel != "self" {
+		t.Errorf("feed links = %+v", af.Link)
+	}
+	if len(af.Entry) != 2 {
+		t.Fatalf("entries = %+v, want 2", af.Entry)
+	}
+	e := af.Entry[0]
+	if e.ID != "urn:xfreehack:coupon:1" || e.Title != "first" || e.Updated != created.Format(time.RFC3339) ||
+		len(e.Link) != 1 || e.Link[0].Href != "https://example.com/1" || e.Category == nil || e.Category.Term != "lovikod.ru" {
+		t.Errorf("entry = %+v", e)
+	}
+	e = af.Entry[1]
+	if e.Title != "CODE2" || e.Updated != expires.Format(time.RFC3339) || len(e.Link) != 0 || e.Category != nil {
+		t.Errorf("entry without link and source = %+v", e)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	h, _ := newTestFeed(t)
+	w := get(h, "/feed/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/feed+json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var jf jsonFeed
+	err := json.Unmarshal(w.Body.Bytes(), &jf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jf.Version != "https://jsonfeed.org/version/1.1" || jf.FeedURL != "https://xfree.example.com/feed/json" {
+		t.Errorf("feed = %+v", jf)
+	}
+	if len(jf.Items) != 2 {
+		t.Fatalf("items = %+v, want 2", jf.Items)
+	}
+	it := jf.Items[0]
+	if it.ID != "urn:xfreehack:coupon:1" || it.URL != "https://example.com/1" || it.Coupon.Code != "CODE1" ||
+		it.Coupon.Expires != expires.Format(time.RFC3339) || len(it.Tags) != 1 || it.Tags[0] != "lovikod.ru" {
+		t.Errorf("item = %+v", it)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	h, s := newTestFeed(t)
+	s.records = nil
+	var jf map[string]interface{}
+	err := json.Unmarshal(get(h, "/feed/json").Body.Bytes(), &jf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the items are required by JSON Feed.
+	if items, ok := jf["items"].([]interface{}); !ok || len(items) != 0 {
+		t.Errorf("items = %v, want an empty list", jf["items"])
+	}
+}
+
+func TestFilter(t *testing.T) {
+	h, s := newTestFeed(t)
+	var ids []string
+	for _, target := range []string{
+		"/feed/atom?source=lovikod.ru&query=audio&utm=x",
+		"/feed/atom?query=audio&source=lovikod.ru",
+	} {
+		var af atomFeed
+		err := xml.Unmarshal(get(h, target).Body.Bytes(), &af)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := model.CouponFilter{Source: "lovikod.ru", Query: "audio", Limit: 10}
+		if s.filter != want {
+			t.Errorf("%s: filter = %+v, want %+v", target, s.filter, want)
+		}
+		ids = append(ids, af.ID)
+	}
+	// the id does not depend on the order of the parameters.
+	if ids[0] != ids[1] || ids[0] != "urn:xfreehack:feed:feed/atom?query=audio&source=lovikod.ru" {
+		t.Errorf("feed ids = %q", ids)
+	}
+}
+
+func TestMethod(t *testing.T) {
+	h, _ := newTestFeed(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/feed/json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Check reports an error when a dependency of the service is not usable.
+type Check func(ctx context.Context) error
+
+type Config struct {
+	Logger log.Logger
+	// Checks are run by the readiness probe, the key names the check.
+	Checks map[string]Check
+	// Timeout limits the time of all checks, 5 seconds by default.
+	Timeout time.Duration
+}
+
+// Health serves the liveness and the readiness probes.
+type Health struct {
+	cfg *Config
+}
+
+func New(cfg *Config) (*Health, error) {
+	if len(cfg.Checks) == 0 {
+		return nil, errors.New("checks are empty")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &Health{cfg: cfg}, nil
+}
+
+// Recent returns the check failing when the time returned by last
+// is zero or older than maxAge.
+func Recent(last func() time.Time, maxAge time.Duration) Check {
+	return func(context.Context) error {
+		t := last()
+		if t.IsZero() {
+			return errors.New("never succeeded")
+		}
+		if age := time.Since(t); age > maxAge {
+			return fmt.Errorf("last succeeded %s ago", age.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// Handler returns the handler of /healthz, answering while the process
+// is alive, and /readyz, answering 503 when a check fails.
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, status{Status: "ok"})
+	})
+	mux.HandleFunc("/readyz", h.ready)
+	return mux
+}
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h *Health) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.Timeout)
+	defer cancel()
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		st = status{Status: "ok", Checks: make(map[string]string, len(h.cfg.Checks))}
+	)
+	for name, check := range h.cfg.Checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			res := "ok"
+			err := run(ctx, check)
+			if err != nil {
+				res = err.Error()
+			}
+			mu.Lock()
+			st.Checks[name] = res
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	names := make([]string, 0, len(st.Checks))
+	for name := range st.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if st.Checks[name] != "ok" {
+			st.Status = "fail"
+			level.Warn(h.cfg.Logger).Log("msg", "readiness check failed", "check", name, "err", st.Checks[name])
+		}
+	}
+	code := http.StatusOK
+	if st.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, st)
+}
+
+// run returns the check error or the context error if the check
+// does not return in time.
+func run(ctx context.Context, check Check) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- check(ctx)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, h *Health, path string) (int, status) {
+	w := httptest.NewRecorder()
+	h.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var st status
+	err := json.Unmarshal(w.Body.Bytes(), &st)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return w.Code, st
+}
+
+func TestProbes(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	// hang ignores the context to check the probe does not wait for it.
+	hang := func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+	for _, tt := range []struct {
+		name   string
+		checks map[string]Check
+		code   int
+		want   map[string]string
+	}{
+		{
+			name:   "ok",
+			checks: map[string]Check{"db": ok, "telegram": ok},
+			code:   http.StatusOK,
+			want:   map[string]string{"db": "ok", "telegram": "ok"},
+		},
+		{
+			name:   "failed",
+			checks: map[string]Check{"db": ok, "telegram": func(context.Context) error { return errors.New("unauthorized") }},
+			code:   http.StatusServiceUnavailable,
+			want:   map[string]string{"db": "ok", "telegram": "unauthorized"},
+		},
+		{
+			name:   "timeout",
+			checks: map[string]Check{"db": hang, "telegram": ok},
+			code:   http.StatusServiceUnavailable,
+			want:   map[string]string{"db": context.DeadlineExceeded.Error(), "telegram": "ok"},
+		},
+	} {
+		h, err := New(&Config{Checks: tt.checks, Timeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		begin := time.Now()
+		code, st := probe(t, h, "/readyz")
+		if d := time.Since(begin); d > 500*time.Millisecond {
+			t.Errorf("%s: probe took %s", tt.name, d)
+		}
+		if code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.code)
+		}
+		if wantStatus := map[bool]string{true: "ok", false: "fail"}[tt.code == http.StatusOK]; st.Status != wantStatus {
+			t.Errorf("%s: status %q, want %q", tt.name, st.Status, wantStatus)
+		}
+		if len(st.Checks) != len(tt.want) {
+			t.Errorf("%s: checks = %v, want %v", tt.name, st.Checks, tt.want)
+		}
+		for k, v := range tt.want {
+			if st.Checks[k] != v {
+				t.Errorf("%s: check %s = %q, want %q", tt.name, k, st.Checks[k], v)
+			}
+		}
+		// the process is alive whatever the checks are.
+		code, st = probe(t, h, "/healthz")
+		if code != http.StatusOK || st.Status != "ok" || st.Checks != nil {
+			t.Errorf("%s: /healthz = %d %+v", tt.name, code, st)
+		}
+	}
+}
+
+func TestRecent(t *testing.T) {
+	var last time.Time
+	check := Recent(func() time.Time { return last }, time.Minute)
+	ctx := context.Background()
+	if err := check(ctx); err == nil {
+		t.Error("never succeeded check passed")
+	}
+	last = time.Now().Add(-30 * time.Second)
+	if err := check(ctx); err != nil {
+		t.Errorf("recent success: %v", err)
+	}
+	last = time.Now().Add(-2 * time.Minute)
+	if err := check(ctx); err == nil {
+		t.Error("stale success passed")
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&Config{})
+	if err == nil {
+		t.Error("health without checks is created")
+	}
+}
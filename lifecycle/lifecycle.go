@@ -0,0 +1,132 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// ErrTimeout is returned when the components did not stop in time.
+var ErrTimeout = errors.New("shutdown timed out")
+
+type Config struct {
+	Logger log.Logger
+	// Timeout is how long the components are waited for on shutdown.
+	Timeout time.Duration
+}
+
+// Manager runs the long living components of the service and stops
+// them together by cancelling their context.
+type Manager struct {
+	cfg    *Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// failed is closed when the first component returns before shutdown,
+	// err is why it did.
+	failed   chan struct{}
+	failOnce sync.Once
+	err      error
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func New(cfg *Config) (*Manager, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg:     cfg,
+		ctx:     ctx,
+		cancel:  cancel,
+		failed:  make(chan struct{}),
+		running: make(map[string]bool),
+	}, nil
+}
+
+// Go starts the component, run must return soon after its context is done.
+// A component stopping on its own shuts the service down.
+func (m *Manager) Go(name string, run func(ctx context.Context) error) {
+	m.mu.Lock()
+	m.running[name] = true
+	m.mu.Unlock()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := run(m.ctx)
+		m.mu.Lock()
+		delete(m.running, name)
+		m.mu.Unlock()
+		if m.ctx.Err() != nil {
+			level.Info(m.cfg.Logger).Log("msg", "component stopped", "component", name)
+			return
+		}
+		level.Error(m.cfg.Logger).Log("msg", "component stopped unexpectedly", "component", name, "err", err)
+		m.failOnce.Do(func() {
+			if err == nil {
+				m.err = fmt.Errorf("component %s stopped unexpectedly", name)
+			} else {
+				m.err = fmt.Errorf("component %s failed: %w", name, err)
+			}
+			close(m.failed)
+		})
+	}()
+}
+
+// Wait blocks until one of the signals is received or a component stops,
+// then shuts all the components down. The error of the stopped component
+// is returned together with the shutdown error, if any.
+func (m *Manager) Wait(signals ...os.Signal) error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+	select {
+	case sig := <-ch:
+		level.Info(m.cfg.Logger).Log("msg", "received signal, exiting", "signal", sig)
+		return m.Shutdown()
+	case <-m.failed:
+	}
+	err := m.Shutdown()
+	if err != nil {
+		return fmt.Errorf("%w; %v", m.err, err)
+	}
+	return m.err
+}
+
+// Shutdown cancels the components and waits for them at most Timeout,
+// it returns an error naming the components still running.
+func (m *Manager) Shutdown() error {
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(m.cfg.Timeout):
+	}
+	m.mu.Lock()
+	var names []string
+	for name := range m.running {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	return fmt.Errorf("%w: %s", ErrTimeout, strings.Join(names, ", "))
+}
@@ -0,0 +1,45 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitComponentFailed(t *testing.T) {
+	m, err := New(&Config{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFailed := errors.New("failed")
+	m.Go("failing", func(ctx context.Context) error {
+		return errFailed
+	})
+	m.Go("stuck", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	err = m.Wait()
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Wait() = %v, want the component error", err)
+	}
+	if !strings.Contains(err.Error(), ErrTimeout.Error()+": stuck") {
+		t.Fatalf("Wait() = %v, want the shutdown error", err)
+	}
+}
+
+func TestWaitComponentReturned(t *testing.T) {
+	m, err := New(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Go("done", func(ctx context.Context) error {
+		return nil
+	})
+	err = m.Wait()
+	if err == nil || !strings.Contains(err.Error(), "done") {
+		t.Fatalf("Wait() = %v, want an error naming the component", err)
+	}
+}
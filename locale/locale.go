@@ -0,0 +1,111 @@
+package locale
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lang is a language of the bot messages.
+type Lang string
+
+const (
+	Russian Lang = "ru"
+	English Lang = "en"
+
+	// Default is used when the language is unknown.
+	Default = Russian
+)
+
+// Langs are the supported languages.
+var Langs = []Lang{Russian, English}
+
+// Name returns the language name in the language itself.
+func (l Lang) Name() string {
+	return T(l, "language.name")
+}
+
+// Parse returns the supported language with the code: ru, en.
+func Parse(code string) (Lang, bool) {
+	for _, l := range Langs {
+		if strings.EqualFold(code, string(l)) {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// russianSpeaking are languages whose speakers usually read Russian.
+var russianSpeaking = map[string]bool{
+	"ru": true,
+	"uk": true,
+	"be": true,
+	"kk": true,
+}
+
+// Detect picks the language for the IETF language tag of a Telegram user,
+// English is used for the languages not supported.
+func Detect(tag string) Lang {
+	if tag == "" {
+		return Default
+	}
+	code := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+	if l, ok := Parse(code); ok {
+		return l
+	}
+	if russianSpeaking[code] {
+		return Russian
+	}
+	return English
+}
+
+// T returns the message formatted with the arguments. Messages missing
+// in the language are taken from the Default one.
+func T(l Lang, key string, args ...interface{}) string {
+	msg := lookup(l, key)
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// N returns the plural form of the message for n formatted with the
+// arguments, the forms are separated with "|" in the catalog.
+func N(l Lang, key string, n int, args ...interface{}) string {
+	forms := strings.Split(lookup(l, key), "|")
+	i := plural(l, n)
+	if i >= len(forms) {
+		i = len(forms) - 1
+	}
+	return fmt.Sprintf(forms[i], args...)
+}
+
+func lookup(l Lang, key string) string {
+	if msg, ok := catalog[l][key]; ok {
+		return msg
+	}
+	if msg, ok := catalog[Default][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// plural returns the index of the plural form of n: one, few and many
+// for Russian, one and other for English.
+func plural(l Lang, n int) int {
+	if n < 0 {
+		n = -n
+	}
+	if l != Russian {
+		if n == 1 {
+			return 0
+		}
+		return 1
+	}
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return 0
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return 1
+	}
+	return 2
+}
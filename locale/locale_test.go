@@ -0,0 +1,44 @@
+package locale
+
+import "testing"
+
+func TestN(t *testing.T) {
+	for _, tt := range []struct {
+		lang Lang
+		n    int
+		want string
+	}{
+		{Russian, 0, "В базе осталось 0 купонов"},
+		{Russian, 1, "В базе остался 1 купон"},
+		{Russian, 2, "В базе осталось 2 купона"},
+		{Russian, 5, "В базе осталось 5 купонов"},
+		{Russian, 11, "В базе осталось 11 купонов"},
+		{Russian, 12, "В базе осталось 12 купонов"},
+		{Russian, 14, "В базе осталось 14 купонов"},
+		{Russian, 21, "В базе остался 21 купон"},
+		{Russian, 22, "В базе осталось 22 купона"},
+		{Russian, 25, "В базе осталось 25 купонов"},
+		{Russian, 111, "В базе осталось 111 купонов"},
+		{English, 0, "0 more coupons in the database"},
+		{English, 1, "1 more coupon in the database"},
+		{English, 2, "2 more coupons in the database"},
+		{English, 11, "11 more coupons in the database"},
+		{English, 21, "21 more coupons in the database"},
+	} {
+		if got := N(tt.lang, "coupons.remain", tt.n, tt.n); got != tt.want {
+			t.Errorf("N(%s, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	for _, l := range Langs {
+		for _, other := range Langs {
+			for key := range catalog[other] {
+				if _, ok := catalog[l][key]; !ok {
+					t.Errorf("%s has no %q", l, key)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package locale
+
+// catalog holds the messages of every language by key.
+var catalog = map[Lang]map[string]string{
+	Russian: {
+		"language.name": "русский",
+
+		"info": `Доброго времени суток, вас приветствует xFree Bot!
+Предназначенный собирать купоны и постить их в этот чат каждый день в 18:00 по МСК.
+Купоны будут поступать по мере их нахождения.
+Если вы хотите получить прямо сейчас те купоны которые имеются у бота можете отправить команду /print 5 (кол-во купонов по умолчанию 5).
+Список всех команд: /help
+Чтобы поделиться купоном в любом чате, наберите @имя_бота и часть названия.
+https://t.me/XFRebot - группа в которой можно задать вопросы по боту.`,
+		"hint":            "Чтобы получить купоны отправьте /print, список команд: /help",
+		"unknown_command": "Неизвестная команда, список команд: /help",
+		"chat_admin_only": "Эту команду могут использовать только администраторы чата.",
+		"rate_limited":    "Слишком много запросов, попробуйте через минуту.",
+		"unavailable":     "Сервис временно недоступен, попробуйте позже.",
+		"stopped":         "Рассылка купонов остановлена, чтобы возобновить отправьте /start.",
+
+		"cmd.start":         "подписаться на ежедневную рассылку купонов",
+		"cmd.stop":          "остановить рассылку",
+		"cmd.print":         "получить купоны прямо сейчас, /print 10",
+		"cmd.history":       "полученные купоны и их статус",
+		"cmd.saved":         "сохранённые купоны",
+		"cmd.help":          "список команд",
+		"cmd.language":      "язык бота",
+		"cmd.stat":          "статистика сервиса, /stat 30",
+		"cmd.announce":      "создать объявление для всех пользователей",
+		"cmd.announcements": "последние объявления",
+		"cmd.admin":         "управление администраторами",
+
+		"usage.print":   "Использование: /print [количество от 1 до 100]",
+		"usage.history": "Использование: /history [количество от 1 до 50]",
+		"usage.stat":    "Использование: /stat [дней от 1 до 90]",
+		"usage.language": `Язык: %s.
+Использование: /language ru|en, /language auto - по языку Telegram.`,
+		"language.set": "Язык бота: %s.",
+
+		"label.code":     "Код",
+		"label.expires":  "Действует до",
+		"label.link":     "Перейти",
+		"label.until":    "до",
+		"coupon.title":   "%s (до %s)",
+		"coupons.empty":  "Вы получили все доступные купоны на данный момент.",
+		"coupons.remain": "В базе остался %d купон|В базе осталось %d купона|В базе осталось %d купонов",
+		"page":           "Страница %d/%d",
+		"page.more":      "Ещё ▶▶",
+		"page.expired":   "Список устарел, отправьте /print ещё раз.",
+		"vote.thanks":    "Спасибо за отзыв!",
+
+		"status.0":        "новый",
+		"status.1":        "получен",
+		"status.2":        "использован",
+		"status.3":        "сохранён",
+		"status.4":        "скрыт",
+		"status.set":      "Статус купона: %s",
+		"status.locked":   "Статус купона нельзя изменить.",
+		"status.notfound": "Купон не найден в вашей истории.",
+
+		"history.empty":   "Вы ещё не получали купонов.",
+		"history.item":    "%d: %s — %s, до %s",
+		"history.expired": " (истёк)",
+		"saved.empty":     "У вас нет сохранённых купонов.",
+
+		"stat.active":     "Активных пользователей в базе: %d\n",
+		"stat.days":       "\nПо дням за %d день (новые / ушедшие / доставлено):\n|\nПо дням за %d дня (новые / ушедшие / доставлено):\n|\nПо дням за %d дней (новые / ушедшие / доставлено):\n",
+		"stat.sources":    "\nСобрано купонов по источникам:\n",
+		"stat.unknown":    "неизвестно",
+		"stat.engagement": "\nВовлечённость:\n",
+		"stat.clicks":     "Просмотров кода: %d (%s доставок)\n",
+		"stat.votes":      "Отзывы: ✅ %d ❌ %d (%s доставок)\n",
+		"stat.queries":    "\nПопулярные запросы:\n",
+		"stat.retention":  "\nУдержание по неделям (пришли / активны):\n",
+
+		"announce.usage": `Использование: /announce [ДД.ММ.ГГГГ ЧЧ:ММ] текст
+Без даты объявление будет отправлено сразу после подтверждения.`,
+		"announce.now":       "сразу после подтверждения",
+		"announce.preview":   "Предпросмотр объявления (отправка: %s):\n\n%s",
+		"announce.send":      "Отправить",
+		"announce.cancel":    "Отмена",
+		"announce.forbidden": "Недостаточно прав.",
+		"announce.processed": "Объявление уже обработано.",
+		"announce.queued":    "Объявление поставлено в очередь.",
+		"announce.canceled":  "Объявление отменено.",
+		"announce.empty":     "Объявлений нет.",
+		"announce.draft":     "черновик",
+		"announce.progress":  "доставлено %d, в очереди %d, ошибок %d",
+		"announce.scheduled": "запланировано на %s",
+
+		"admin.usage": `Использование:
+/admin list
+/admin add ID viewer|admin|owner
+/admin remove ID`,
+		"admin.granted": "Пользователь %d получил роль %s.",
+		"admin.removed": "Пользователь %d больше не администратор.",
+
+		"alert.hidden":      "Купон скрыт по отзывам (рейтинг %d):\n%s\nКод: %s",
+		"alert.send_failed": "Ошибка отправки в чат %d: %s",
+	},
+	English: {
+		"language.name": "English",
+
+		"info": `Hello, this is xFree Bot!
+It collects coupons and posts them to this chat every day at 18:00 Moscow time.
+Coupons are sent as soon as they are found.
+To get the coupons the bot has right now send /print 5 (5 coupons by default).
+All commands: /help
+To share a coupon in any chat type @bot_name and a part of its name.
+https://t.me/XFRebot - the group for questions about the bot.`,
+		"hint":            "Send /print to get coupons, all commands: /help",
+		"unknown_command": "Unknown command, all commands: /help",
+		"chat_admin_only": "Only chat administrators may use this command.",
+		"rate_limited":    "Too many requests, try again in a minute.",
+		"unavailable":     "Service temporarily unavailable, try again later.",
+		"stopped":         "Coupons are no longer sent, send /start to resume.",
+
+		"cmd.start":         "subscribe to the daily coupons",
+		"cmd.stop":          "stop the daily coupons",
+		"cmd.print":         "get coupons right now, /print 10",
+		"cmd.history":       "received coupons and their status",
+		"cmd.saved":         "saved coupons",
+		"cmd.help":          "list of commands",
+		"cmd.language":      "bot language",
+		"cmd.stat":          "service statistics, /stat 30",
+		"cmd.announce":      "create an announcement for all users",
+		"cmd.announcements": "last announcements",
+		"cmd.admin":         "manage administrators",
+
+		"usage.print":   "Usage: /print [count from 1 to 100]",
+		"usage.history": "Usage: /history [count from 1 to 50]",
+		"usage.stat":    "Usage: /stat [days from 1 to 90]",
+		"usage.language": `Language: %s.
+Usage: /language ru|en, /language auto - the Telegram language.`,
+		"language.set": "Bot language: %s.",
+
+		"label.code":     "Code",
+		"label.expires":  "Valid until",
+		"label.link":     "Open",
+		"label.until":    "until",
+		"coupon.title":   "%s (until %s)",
+		"coupons.empty":  "You have received all the coupons available at the moment.",
+		"coupons.remain": "%d more coupon in the database|%d more coupons in the database",
+		"page":           "Page %d/%d",
+		"page.more":      "More ▶▶",
+		"page.expired":   "The list is outdated, send /print again.",
+		"vote.thanks":    "Thanks for the feedback!",
+
+		"status.0":        "new",
+		"status.1":        "received",
+		"status.2":        "used",
+		"status.3":        "saved",
+		"status.4":        "hidden",
+		"status.set":      "Coupon status: %s",
+		"status.locked":   "The coupon status can not be changed.",
+		"status.notfound": "The coupon is not in your history.",
+
+		"history.empty":   "You have not received any coupons yet.",
+		"history.item":    "%d: %s — %s, until %s",
+		"history.expired": " (expired)",
+		"saved.empty":     "You have no saved coupons.",
+
+		"stat.active":     "Active users: %d\n",
+		"stat.days":       "\nLast %d day (joined / left / delivered):\n|\nLast %d days (joined / left / delivered):\n",
+		"stat.sources":    "\nCoupons collected by source:\n",
+		"stat.unknown":    "unknown",
+		"stat.engagement": "\nEngagement:\n",
+		"stat.clicks":     "Code views: %d (%s of deliveries)\n",
+		"stat.votes":      "Votes: ✅ %d ❌ %d (%s of deliveries)\n",
+		"stat.queries":    "\nTop queries:\n",
+		"stat.retention":  "\nWeekly retention (joined / active):\n",
+
+		"announce.usage": `Usage: /announce [DD.MM.YYYY HH:MM] text
+Without the date the announcement is sent right after the confirmation.`,
+		"announce.now":       "right after the confirmation",
+		"announce.preview":   "Announcement preview (sending: %s):\n\n%s",
+		"announce.send":      "Send",
+		"announce.cancel":    "Cancel",
+		"announce.forbidden": "Not enough rights.",
+		"announce.processed": "The announcement is already processed.",
+		"announce.queued":    "The announcement is queued.",
+		"announce.canceled":  "The announcement is canceled.",
+		"announce.empty":     "No announcements.",
+		"announce.draft":     "draft",
+		"announce.progress":  "delivered %d, queued %d, failed %d",
+		"announce.scheduled": "scheduled at %s",
+
+		"admin.usage": `Usage:
+/admin list
+/admin add ID viewer|admin|owner
+/admin remove ID`,
+		"admin.granted": "User %d is granted the %s role.",
+		"admin.removed": "User %d is not an administrator anymore.",
+
+		"alert.hidden":      "Coupon hidden by votes (score %d):\n%s\nCode: %s",
+		"alert.send_failed": "Failed to send to chat %d: %s",
+	},
+}
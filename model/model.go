@@ -1,7 +1,226 @@
 package model
 
+import (
+	"errors"
+
+	"github.com/wenkaler/xfreehack/collector"
+)
+
 type Notification struct {
-	ID      int64
-	Message string
-	Status  bool
+	ID      int64  `db:"id"`
+	Message string `db:"message"`
+	// Status is true once the notification is put to the outbox.
+	Status bool `db:"send"`
+	// Confirmed is false until an admin approves the preview.
+	Confirmed bool `db:"confirmed"`
+	// SendAt is the time the notification is scheduled for.
+	SendAt    int64 `db:"send_at"`
+	CreatedBy int64 `db:"created_by"`
+	Created   int64 `db:"created"`
+}
+
+// NotificationStat is the delivery progress of a notification.
+type NotificationStat struct {
+	Notification
+	Pending int `db:"pending"`
+	Sent    int `db:"delivered"`
+	Dead    int `db:"dead"`
+}
+
+// CouponStatus is the state of a coupon delivered to a chat.
+type CouponStatus int
+
+const (
+	StatusNew CouponStatus = iota
+	StatusSent
+	StatusUsed
+	StatusSaved
+	StatusDismissed
+)
+
+var ErrBadTransition = errors.New("coupon status transition is not allowed")
+
+var transitions = map[CouponStatus][]CouponStatus{
+	StatusNew:       {StatusSent},
+	StatusSent:      {StatusUsed, StatusSaved, StatusDismissed},
+	StatusSaved:     {StatusSent, StatusUsed, StatusDismissed},
+	StatusDismissed: {StatusSaved},
+}
+
+// CanChange reports whether the status may be changed to the next one.
+func (s CouponStatus) CanChange(next CouponStatus) bool {
+	for _, t := range transitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryRecord is a coupon received by a chat.
+type HistoryRecord struct {
+	collector.Record
+	Status  CouponStatus `db:"status"`
+	Updated int64        `db:"updated"`
+}
+
+// OutboxStatus is the delivery state of an outgoing message.
+type OutboxStatus int
+
+const (
+	OutboxPending OutboxStatus = iota
+	OutboxSent
+	OutboxDead
+)
+
+// OutboxMessage is a message waiting for the delivery.
+type OutboxMessage struct {
+	ID     int64 `db:"id"`
+	ChatID int64 `db:"id_chat"`
+	// Channel is the @username of the channel the message is posted to,
+	// ChatID is zero then.
+	Channel string `db:"channel"`
+	Text    string `db:"message"`
+	// Markup is the JSON encoded inline keyboard.
+	Markup string `db:"markup"`
+	// ParseMode is the Telegram parse mode of the text, plain text when empty.
+	ParseMode string `db:"parse_mode"`
+	// Records are comma separated ids of the coupons in the message,
+	// they are marked as read once the message is delivered.
+	Records string `db:"records"`
+	// Chunks is the number of parts of a long message already delivered,
+	// they are skipped when the delivery is retried.
+	Chunks      int          `db:"chunks"`
+	Status      OutboxStatus `db:"status"`
+	Attempts    int          `db:"attempts"`
+	NextAttempt int64        `db:"next_attempt"`
+	LastError   string       `db:"last_error"`
+	Created     int64        `db:"created"`
+}
+
+// Delivery is the progress of an outbox message delivery.
+type Delivery struct {
+	// ChatID is the chat the message is sent to, it differs from the chat
+	// of the message when the group was upgraded to a supergroup.
+	ChatID int64
+	// Chunks is the number of parts of the message delivered so far.
+	Chunks int
+}
+
+// Role is the access level of an admin, higher roles include lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RoleAdmin:  "admin",
+	RoleOwner:  "owner",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// ParseRole returns the role by its name.
+func ParseRole(name string) (Role, bool) {
+	for r, n := range roleNames {
+		if n == name && r != RoleNone {
+			return r, true
+		}
+	}
+	return RoleNone, false
+}
+
+// Admin is a telegram user with access to the admin commands.
+type Admin struct {
+	UserID  int64 `db:"user_id"`
+	Role    Role  `db:"role"`
+	AddedBy int64 `db:"added_by"`
+	Created int64 `db:"created"`
+}
+
+// AuditEntry is a record about an admin action.
+type AuditEntry struct {
+	UserID  int64  `db:"user_id"`
+	ChatID  int64  `db:"id_chat"`
+	Action  string `db:"action"`
+	Args    string `db:"args"`
+	Allowed bool   `db:"allowed"`
+	Created int64  `db:"created"`
+	// Actor is who acted when it is not a telegram user, e.g. "api:admin"
+	// for an API key with the admin role.
+	Actor string `db:"actor"`
+}
+
+// EventCode is a request of the coupon code from the inline keyboard.
+const EventCode = "code"
+
+// DayStat is the activity of a single day.
+type DayStat struct {
+	Day       string `db:"day" json:"day"`
+	Joined    int    `db:"joined" json:"joined"`
+	Left      int    `db:"left" json:"left"`
+	Delivered int    `db:"delivered" json:"delivered"`
+}
+
+// CountStat is a number of items by a key: a source, a query or a cohort.
+type CountStat struct {
+	Key   string `db:"key" json:"key"`
+	Count int    `db:"count" json:"count"`
+}
+
+// CohortStat is the number of chats joined in a week and still active.
+type CohortStat struct {
+	Week   string `db:"week" json:"week"`
+	Joined int    `db:"joined" json:"joined"`
+	Active int    `db:"active" json:"active"`
+}
+
+// Stats is the service analytics for the last days.
+type Stats struct {
+	ActiveChats int          `json:"active_chats"`
+	Days        []DayStat    `json:"days"`
+	Sources     []CountStat  `json:"sources"`
+	Delivered   int          `json:"delivered"`
+	Clicks      int          `json:"clicks"`
+	VotesUp     int          `json:"votes_up"`
+	VotesDown   int          `json:"votes_down"`
+	TopQueries  []CountStat  `json:"top_queries"`
+	Cohorts     []CohortStat `json:"cohorts"`
+}
+
+// CouponFilter selects coupons, zero fields do not filter.
+type CouponFilter struct {
+	Source string
+	// Query is searched in the code, the description and the link.
+	Query string
+	// ExpiresAfter and ExpiresBefore are unix times, only active coupons
+	// are selected when ExpiresAfter is zero.
+	ExpiresAfter  int64
+	ExpiresBefore int64
+	// Expired selects the expired coupons as well, ExpiresAfter is ignored.
+	Expired bool
+	// Limit is the maximum number of coupons, -1 is unlimited.
+	Limit  int
+	Offset int
+}
+
+// Chat is a chat the bot was added to.
+type Chat struct {
+	ID                 int64  `db:"id" json:"id"`
+	Type               string `db:"type" json:"type"`
+	UserName           string `db:"user_name" json:"user_name"`
+	FirstName          string `db:"first_name" json:"first_name"`
+	LastName           string `db:"last_name" json:"last_name"`
+	Active             bool   `db:"active" json:"active"`
+	DeactivationReason string `db:"deactivation_reason" json:"deactivation_reason,omitempty"`
+	Created            int64  `db:"created" json:"created"`
+	Deactivated        int64  `db:"deactivated" json:"deactivated,omitempty"`
 }
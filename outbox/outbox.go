@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+type Storage interface {
+	EnqueueNotifications() (int, error)
+	GetDueMessages(limit int) ([]model.OutboxMessage, error)
+	MarkDelivered(m model.OutboxMessage) error
+	RetryMessage(m model.OutboxMessage, next time.Time, reason string) error
+	MarkDead(id int64, reason string) error
+}
+
+// Messenger delivers a single message, the delivery is reported
+// even if it failed part way.
+type Messenger interface {
+	Deliver(m model.OutboxMessage) (model.Delivery, error)
+}
+
+type Config struct {
+	Logger      log.Logger
+	Storage     Storage
+	Messenger   Messenger
+	Broadcaster *broadcast.Broadcaster
+	// Interval is how often the outbox is checked for due messages.
+	Interval time.Duration
+	// MaxAttempts is how many times a message is tried before
+	// it is moved to the dead letters.
+	MaxAttempts int
+	// Batch is the maximum number of messages handled per check.
+	Batch int
+}
+
+// Sender delivers messages stored in the outbox, retrying failed ones.
+type Sender struct {
+	cfg *Config
+}
+
+const (
+	minBackoff = 30 * time.Second
+	maxBackoff = time.Hour
+)
+
+func New(cfg *Config) (*Sender, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("storage is empty")
+	}
+	if cfg.Messenger == nil {
+		return nil, errors.New("messenger is empty")
+	}
+	if cfg.Broadcaster == nil {
+		return nil, errors.New("broadcaster is empty")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = 1000
+	}
+	return &Sender{cfg: cfg}, nil
+}
+
+// Run checks the outbox every Interval until the context is done.
+func (s *Sender) Run(ctx context.Context) error {
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			err := s.Flush(ctx)
+			if err != nil {
+				level.Error(s.cfg.Logger).Log("msg", "failed flush outbox", "err", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Flush delivers all due messages, messages of a chat are sent in order.
+// Messages not delivered before the context is done stay in the outbox.
+func (s *Sender) Flush(ctx context.Context) error {
+	n, err := s.cfg.Storage.EnqueueNotifications()
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed enqueue notifications", "err", err)
+	} else if n != 0 {
+		level.Info(s.cfg.Logger).Log("msg", "enqueue notifications", "count", n)
+	}
+	mm, err := s.cfg.Storage.GetDueMessages(s.cfg.Batch)
+	if err != nil {
+		return err
+	}
+	if len(mm) == 0 {
+		return nil
+	}
+	var (
+		chats  []int64
+		byChat = make(map[int64][]model.OutboxMessage)
+	)
+	for _, m := range mm {
+		if _, ok := byChat[m.ChatID]; !ok {
+			chats = append(chats, m.ChatID)
+		}
+		byChat[m.ChatID] = append(byChat[m.ChatID], m)
+	}
+	s.cfg.Broadcaster.Run(ctx, chats, func(chatID int64) error {
+		for _, m := range byChat[chatID] {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			err := s.deliver(m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// deliver sends the message and updates its state in the outbox,
+// the error is returned when the delivery failed.
+func (s *Sender) deliver(m model.OutboxMessage) error {
+	d, err := s.cfg.Messenger.Deliver(m)
+	// a migrated chat gets the rest of the message and the coupons.
+	if d.ChatID != 0 {
+		m.ChatID = d.ChatID
+	}
+	m.Chunks = d.Chunks
+	if err == nil {
+		if err := s.cfg.Storage.MarkDelivered(m); err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed mark delivered", "id", m.ID, "err", err)
+		}
+		return nil
+	}
+	if permanent(err) || m.Attempts+1 >= s.cfg.MaxAttempts {
+		level.Warn(s.cfg.Logger).Log("msg", "message moved to dead letters", "id", m.ID, "chatID", m.ChatID, "err", err)
+		if err := s.cfg.Storage.MarkDead(m.ID, err.Error()); err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed mark dead", "id", m.ID, "err", err)
+		}
+		return err
+	}
+	if err := s.cfg.Storage.RetryMessage(m, time.Now().Add(backoff(m.Attempts)), err.Error()); err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed schedule retry", "id", m.ID, "err", err)
+	}
+	return err
+}
+
+// backoff returns the delay before the next attempt.
+func backoff(attempts int) time.Duration {
+	d := minBackoff << uint(attempts)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d
+}
+
+// permanent reports whether the delivery can never succeed.
+func permanent(err error) bool {
+	p, ok := err.(interface{ Permanent() bool })
+	return ok && p.Permanent()
+}
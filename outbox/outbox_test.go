@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/model"
+	"github.com/wenkaler/xfreehack/storage"
+
+	"github.com/go-kit/kit/log"
+)
+
+// fakeMessenger records the delivered texts, the texts in fail
+// are not delivered.
+type fakeMessenger struct {
+	mu   sync.Mutex
+	sent []string
+	fail map[string]bool
+}
+
+func (m *fakeMessenger) Deliver(om model.OutboxMessage) (model.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail[om.Text] {
+		return model.Delivery{}, errors.New("temporary failure")
+	}
+	m.sent = append(m.sent, om.Text)
+	return model.Delivery{Chunks: 1}, nil
+}
+
+func newTestSender(t *testing.T, m Messenger) (*Sender, *storage.Storage) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := storage.New(filepath.Join(dir, "test.db"), log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	b, err := broadcast.New(&broadcast.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := New(&Config{Storage: s, Messenger: m, Broadcaster: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, s
+}
+
+func TestFlushKeepsOrder(t *testing.T) {
+	m := &fakeMessenger{fail: map[string]bool{"first": true}}
+	o, s := newTestSender(t, m)
+	for _, om := range []model.OutboxMessage{
+		{ChatID: 1, Text: "first"},
+		{ChatID: 1, Text: "second"},
+		{ChatID: 2, Text: "other"},
+	} {
+		err := s.Enqueue(om)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	err := o.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second message waits for the retry of the first one.
+	err = o.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.sent) != 1 || m.sent[0] != "other" {
+		t.Fatalf("sent = %q, want only the other chat", m.sent)
+	}
+
+	mm, err := s.GetDueMessages(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mm) != 0 {
+		t.Fatalf("due messages = %+v, want none before the retry", mm)
+	}
+	// the retry of the first message is due.
+	m.fail = nil
+	err = s.RetryMessage(model.OutboxMessage{ID: 1, ChatID: 1}, time.Now(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"other", "first", "second"}
+	if len(m.sent) != len(want) {
+		t.Fatalf("sent = %q, want %q", m.sent, want)
+	}
+	for i := range want {
+		if m.sent[i] != want[i] {
+			t.Fatalf("sent = %q, want %q", m.sent, want)
+		}
+	}
+}
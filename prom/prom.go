@@ -0,0 +1,269 @@
+// Package prom implements the go-kit metrics and exposes them in the
+// Prometheus text format.
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// DefBuckets are the histogram buckets in seconds for durations of requests.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics exposed by its handler.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric with all its label values.
+type family struct {
+	name    string
+	help    string
+	typ     string
+	buckets []float64
+	// fn computes the value of a gauge or a counter on every scrape.
+	fn func() (float64, error)
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// counts are the observations per bucket, the last one is +Inf.
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic("prom: duplicate metric " + f.name)
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+// NewCounter registers the counter, labels are set with With as go-kit
+// label values: key, value pairs.
+func (r *Registry) NewCounter(name, help string) metrics.Counter {
+	return &counter{f: r.register(&family{name: name, help: help, typ: "counter"})}
+}
+
+// NewCounterFunc registers the counter read by fn on every scrape,
+// the counter is skipped if fn fails.
+func (r *Registry) NewCounterFunc(name, help string, fn func() (float64, error)) {
+	r.register(&family{name: name, help: help, typ: "counter", fn: fn})
+}
+
+// NewGauge registers the gauge.
+func (r *Registry) NewGauge(name, help string) metrics.Gauge {
+	return &gauge{f: r.register(&family{name: name, help: help, typ: "gauge"})}
+}
+
+// NewGaugeFunc registers the gauge computed by fn on every scrape,
+// the gauge is skipped if fn fails.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, error)) {
+	r.register(&family{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// NewHistogram registers the histogram with the upper bounds of the buckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) metrics.Histogram {
+	bb := append([]float64(nil), buckets...)
+	sort.Float64s(bb)
+	return &histogram{f: r.register(&family{name: name, help: help, typ: "histogram", buckets: bb})}
+}
+
+// get returns the series of the label values, creating it if needed.
+// The caller holds f.mu.
+func (f *family) get(lvs []string) *series {
+	if len(lvs)%2 != 0 {
+		lvs = append(lvs, "unknown")
+	}
+	key := strings.Join(lvs, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: lvs}
+		if f.typ == "histogram" {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func with(lvs, more []string) []string {
+	return append(append([]string(nil), lvs...), more...)
+}
+
+type counter struct {
+	f   *family
+	lvs []string
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter {
+	return &counter{f: c.f, lvs: with(c.lvs, labelValues)}
+}
+
+func (c *counter) Add(delta float64) {
+	c.f.mu.Lock()
+	c.f.get(c.lvs).value += delta
+	c.f.mu.Unlock()
+}
+
+type gauge struct {
+	f   *family
+	lvs []string
+}
+
+func (g *gauge) With(labelValues ...string) metrics.Gauge {
+	return &gauge{f: g.f, lvs: with(g.lvs, labelValues)}
+}
+
+func (g *gauge) Set(value float64) {
+	g.f.mu.Lock()
+	g.f.get(g.lvs).value = value
+	g.f.mu.Unlock()
+}
+
+func (g *gauge) Add(delta float64) {
+	g.f.mu.Lock()
+	g.f.get(g.lvs).value += delta
+	g.f.mu.Unlock()
+}
+
+type histogram struct {
+	f   *family
+	lvs []string
+}
+
+func (h *histogram) With(labelValues ...string) metrics.Histogram {
+	return &histogram{f: h.f, lvs: with(h.lvs, labelValues)}
+}
+
+func (h *histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.f.buckets, value)
+	h.f.mu.Lock()
+	s := h.f.get(h.lvs)
+	s.counts[i]++
+	s.count++
+	s.value += value
+	h.f.mu.Unlock()
+}
+
+// Handler returns the handler of the metrics in the text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.write(bw)
+		bw.Flush()
+	})
+}
+
+func (r *Registry) write(w *bufio.Writer) {
+	r.mu.Lock()
+	ff := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		ff = append(ff, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(ff, func(i, j int) bool { return ff[i].name < ff[j].name })
+	for _, f := range ff {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w *bufio.Writer) {
+	if f.fn != nil {
+		v, err := f.fn()
+		if err != nil {
+			return
+		}
+		f.header(w)
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(v))
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f.header(w)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels(s.labels), formatFloat(s.value))
+			continue
+		}
+		var cum uint64
+		for i, n := range s.counts {
+			cum += n
+			le := "+Inf"
+			if i < len(f.buckets) {
+				le = formatFloat(f.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(s.labels, "le", le), cum)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels(s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels(s.labels), s.count)
+	}
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats the label pairs, empty without labels.
+func labels(lvs []string, more ...string) string {
+	lvs = with(lvs, more)
+	if len(lvs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(lvs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, lvs[i], labelEscaper.Replace(lvs[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,65 @@
+package prom
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Requests by path.")
+	c.With("path", `/a"b\c`+"\n").Add(2)
+	// the value of the odd label is unknown.
+	c.With("path").Add(1)
+	r.NewGauge("test_empty", "Never set.")
+	g := r.NewGauge("test_temperature", "Line one.\nLine two \\ end.")
+	g.Set(1.5)
+	g.Add(-3)
+	h := r.NewHistogram("test_duration_seconds", "Durations.", []float64{1, .5})
+	for _, v := range []float64{.25, .5, .75, 2} {
+		h.With("kind", "x").Observe(v)
+	}
+	r.NewGaugeFunc("test_func", "Computed.", func() (float64, error) { return 3, nil })
+	r.NewCounterFunc("test_failed_total", "Failing.", func() (float64, error) { return 0, errors.New("failed") })
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := ioutil.ReadAll(w.Body)
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{kind="x",le="0.5"} 2
+test_duration_seconds_bucket{kind="x",le="1"} 3
+test_duration_seconds_bucket{kind="x",le="+Inf"} 4
+test_duration_seconds_sum{kind="x"} 3.5
+test_duration_seconds_count{kind="x"} 4
+# HELP test_func Computed.
+# TYPE test_func gauge
+test_func 3
+# HELP test_requests_total Requests by path.
+# TYPE test_requests_total counter
+test_requests_total{path="/a\"b\\c\n"} 2
+test_requests_total{path="unknown"} 1
+# HELP test_temperature Line one.\nLine two \\ end.
+# TYPE test_temperature gauge
+test_temperature -1.5
+`
+	if string(body) != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate metric is registered")
+		}
+	}()
+	r.NewGauge("test_total", "")
+}
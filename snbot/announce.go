@@ -0,0 +1,128 @@
+package snbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const cbAnnounce = "ann"
+
+// parseAnnounce splits the command arguments to the optional schedule time and the text.
+func parseAnnounce(args string) (time.Time, string) {
+	args = strings.TrimSpace(args)
+	ss := strings.SplitN(args, " ", 3)
+	if len(ss) == 3 {
+		t, err := time.ParseInLocation("02.01.2006 15:04", ss[0]+" "+ss[1], time.Local)
+		if err == nil {
+			return t, strings.TrimSpace(ss[2])
+		}
+	}
+	return time.Time{}, args
+}
+
+// Announce stores the announcement draft and sends its preview with confirmation buttons.
+func (s *SNBot) Announce(message *tgbotapi.Message, lang locale.Lang) error {
+	at, text := parseAnnounce(message.CommandArguments())
+	if text == "" {
+		return s.Send(message.Chat.ID, locale.T(lang, "announce.usage"))
+	}
+	n := model.Notification{
+		Message:   text,
+		CreatedBy: int64(message.From.ID),
+	}
+	when := locale.T(lang, "announce.now")
+	if !at.IsZero() {
+		n.SendAt = at.Unix()
+		when = at.Format("02.01.2006 15:04")
+	}
+	id, err := s.cfg.Storage.NewNotification(n)
+	if err != nil {
+		return fmt.Errorf("failed create notification: %v", err)
+	}
+	m := tgbotapi.NewMessage(message.Chat.ID, locale.T(lang, "announce.preview", when, text))
+	m.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(locale.T(lang, "announce.send"), callbackData(cbAnnounce, strconv.FormatInt(id, 10), "ok")),
+		tgbotapi.NewInlineKeyboardButtonData(locale.T(lang, "announce.cancel"), callbackData(cbAnnounce, strconv.FormatInt(id, 10), "cancel")),
+	))
+	_, err = s.send(m)
+	return err
+}
+
+// confirmAnnounce handles the preview buttons and returns the callback answer.
+func (s *SNBot) confirmAnnounce(q *tgbotapi.CallbackQuery, lang locale.Lang, args []string) (string, error) {
+	allowed, err := s.authorize(q.From, q.Message.Chat.ID, model.RoleAdmin, "announce_confirm", strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return locale.T(lang, "announce.forbidden"), nil
+	}
+	if len(args) != 2 {
+		return "", fmt.Errorf("bad callback data: %q", q.Data)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("bad callback data: %q", q.Data)
+	}
+	var answer string
+	switch args[1] {
+	case "ok":
+		ok, err := s.cfg.Storage.ConfirmNotification(id)
+		if err != nil {
+			return "", fmt.Errorf("failed confirm notification: %v", err)
+		}
+		if !ok {
+			return locale.T(lang, "announce.processed"), nil
+		}
+		level.Info(s.cfg.Logger).Log("msg", "notification confirmed", "id", id, "user", q.From.ID)
+		answer = locale.T(lang, "announce.queued")
+	case "cancel":
+		err := s.cfg.Storage.DeleteNotification(id)
+		if err != nil {
+			return "", fmt.Errorf("failed delete notification: %v", err)
+		}
+		answer = locale.T(lang, "announce.canceled")
+	default:
+		return "", fmt.Errorf("bad callback data: %q", q.Data)
+	}
+	_, err = s.bot.Send(tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed remove preview buttons", "err", err)
+	}
+	return answer, nil
+}
+
+// SendAnnouncements sends the delivery progress of the last announcements.
+func (s *SNBot) SendAnnouncements(chatID int64, lang locale.Lang) error {
+	nn, err := s.cfg.Storage.GetNotificationStats(5)
+	if err != nil {
+		return fmt.Errorf("failed get notifications: %v", err)
+	}
+	if len(nn) == 0 {
+		return s.Send(chatID, locale.T(lang, "announce.empty"))
+	}
+	var b strings.Builder
+	for _, n := range nn {
+		state := locale.T(lang, "announce.draft")
+		switch {
+		case n.Status:
+			state = locale.T(lang, "announce.progress", n.Sent, n.Pending, n.Dead)
+		case n.Confirmed:
+			state = locale.T(lang, "announce.scheduled", time.Unix(n.SendAt, 0).Format("02.01.2006 15:04"))
+		}
+		text := n.Message
+		if r := []rune(text); len(r) > 50 {
+			text = string(r[:50]) + "…"
+		}
+		fmt.Fprintf(&b, "#%d %s\n%s\n\n", n.ID, text, state)
+	}
+	return s.Send(chatID, b.String())
+}
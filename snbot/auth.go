@@ -0,0 +1,90 @@
+package snbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// seedOwners grants the owner role to the configured admins.
+func (s *SNBot) seedOwners() error {
+	for _, id := range s.cfg.Admins {
+		err := s.cfg.Storage.SetAdmin(model.Admin{UserID: id, Role: model.RoleOwner})
+		if err != nil {
+			return fmt.Errorf("failed seed owner %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// authorize reports whether the user has the role, the attempt is audited.
+func (s *SNBot) authorize(u *tgbotapi.User, chatID int64, role model.Role, action, args string) (bool, error) {
+	if u == nil {
+		return false, nil
+	}
+	have, err := s.cfg.Storage.GetRole(int64(u.ID))
+	if err != nil {
+		return false, fmt.Errorf("failed get role: %v", err)
+	}
+	allowed := have >= role
+	level.Info(s.cfg.Logger).Log("msg", "admin action", "user", u.ID, "chatID", chatID, "action", action, "role", have, "allowed", allowed)
+	err = s.cfg.Storage.Audit(model.AuditEntry{
+		UserID:  int64(u.ID),
+		ChatID:  chatID,
+		Action:  action,
+		Args:    args,
+		Allowed: allowed,
+	})
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed write audit", "err", err)
+	}
+	return allowed, nil
+}
+
+// ManageAdmins handles the /admin command.
+func (s *SNBot) ManageAdmins(message *tgbotapi.Message, lang locale.Lang) error {
+	ss := strings.Fields(message.CommandArguments())
+	if len(ss) == 0 {
+		return s.Send(message.Chat.ID, locale.T(lang, "admin.usage"))
+	}
+	switch {
+	case ss[0] == "list":
+		aa, err := s.cfg.Storage.GetAdmins(model.RoleViewer)
+		if err != nil {
+			return fmt.Errorf("failed get admins: %v", err)
+		}
+		var b strings.Builder
+		for _, a := range aa {
+			fmt.Fprintf(&b, "%d — %s\n", a.UserID, a.Role)
+		}
+		return s.Send(message.Chat.ID, b.String())
+	case ss[0] == "add" && len(ss) == 3:
+		id, err := strconv.ParseInt(ss[1], 10, 64)
+		role, ok := model.ParseRole(ss[2])
+		if err != nil || !ok {
+			return s.Send(message.Chat.ID, locale.T(lang, "admin.usage"))
+		}
+		err = s.cfg.Storage.SetAdmin(model.Admin{UserID: id, Role: role, AddedBy: int64(message.From.ID)})
+		if err != nil {
+			return fmt.Errorf("failed set admin: %v", err)
+		}
+		return s.Send(message.Chat.ID, locale.T(lang, "admin.granted", id, role))
+	case ss[0] == "remove" && len(ss) == 2:
+		id, err := strconv.ParseInt(ss[1], 10, 64)
+		if err != nil {
+			return s.Send(message.Chat.ID, locale.T(lang, "admin.usage"))
+		}
+		err = s.cfg.Storage.RemoveAdmin(id)
+		if err != nil {
+			return fmt.Errorf("failed remove admin: %v", err)
+		}
+		return s.Send(message.Chat.ID, locale.T(lang, "admin.removed", id))
+	}
+	return s.Send(message.Chat.ID, locale.T(lang, "admin.usage"))
+}
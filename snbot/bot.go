@@ -1,158 +1,453 @@
 package snbot
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"html"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
-const info = `Доброго времени суток, вас приветствует xFree Bot!
-Предназначенный собирать купоны и постить их в этот чат каждый день в 18:00 по МСК.
-Купоны будут поступать по мере их нахождения. 
-Если вы хотите получить прямо сейчас те купоны которые имеются у бота можете отправить команду /print 5 (кол-во купонов по умолчанию 5).
-https://t.me/XFRebot - группа в которой можно задать вопросы по боту.`
-
-const errBlockedByUser = "Forbidden: bot was blocked by the user"
-
 type Storage interface {
+	GetLanguage(cid int64) (string, error)
+	SetLanguage(cid int64, lang string) error
 	GetNotUseCoupon(cid int64) ([]collector.Record, error)
 	GetNotUseCouponCount(cid, count int64) ([]collector.Record, error)
-	GetCountUser() (int, error)
+	GetStats(days int) (model.Stats, error)
+	LogEvent(cid int64, id string, kind string) error
+	LogSearch(uid int64, query string) error
 	CountNotUseCoupon(cid int64) (uint64, error)
+	GetRecord(id string) (collector.Record, error)
 	MarkAsRead(cid int64, rr []collector.Record) error
+	Vote(cid int64, id string, vote int) (int, error)
+	HideRecord(id string) (bool, error)
+	SetCouponStatus(cid int64, id string, status model.CouponStatus) error
+	GetHistory(cid, count int64) ([]model.HistoryRecord, error)
+	GetSaved(cid int64) ([]collector.Record, error)
+	SearchCoupons(query string, limit, offset int) ([]collector.Record, error)
+	Enqueue(m model.OutboxMessage) error
+	HasPending(cid int64) (bool, error)
+	GetRole(uid int64) (model.Role, error)
+	GetAdmins(role model.Role) ([]model.Admin, error)
+	SetAdmin(a model.Admin) error
+	RemoveAdmin(uid int64) error
+	Audit(e model.AuditEntry) error
+	NewNotification(n model.Notification) (int64, error)
+	ConfirmNotification(id int64) (bool, error)
+	DeleteNotification(id int64) error
+	GetNotificationStats(count int64) ([]model.NotificationStat, error)
 	NewChat(chat *tgbotapi.Chat) error
-	UpdChatActivity(cid int64, act bool) error
+	DeactivateChat(cid int64, reason string) error
+	MigrateChat(from, to int64) error
+	GetNotPosted(channel, filter string) ([]collector.Record, error)
+	EnqueuePost(channel string, id string, m model.OutboxMessage) error
+	SkipPost(channel, filter, id string) error
 }
 
 type Config struct {
-	Logger      log.Logger
-	Storage     Storage
-	Token       string
-	UpdateTime  int
-	AccessToken string
+	Logger  log.Logger
+	Storage Storage
+	Token   string
+	// Messenger is the transport to Telegram, created with Token when nil.
+	Messenger  Messenger
+	UpdateTime int
+	// Admins are telegram user ids granted the owner role on start.
+	Admins []int64
+	// HideScore is the vote score at which a coupon stops being sent,
+	// zero hides a coupon at the first down vote. The service sets it
+	// from HIDE_SCORE, -3 by default.
+	HideScore int
+	// Channels are the channels new coupons are posted to.
+	Channels []Channel
+	// Limiter limits the rate of outgoing messages.
+	Limiter Limiter
+	// Webhook enables the webhook mode, updates are long polled when nil.
+	Webhook *Webhook
+	// Workers is the number of updates processed concurrently, 4 by default.
+	Workers int
+	// QueueSize is the number of updates waiting for a worker, receiving
+	// updates blocks when the queue is full. 100 by default.
+	QueueSize int
+	// Templates is a text/template file redefining the "coupon" and
+	// "coupon_short" templates, see defaultTemplates.
+	Templates string
+	// LinkPreview shows link previews in coupon messages.
+	LinkPreview bool
+	// Sent counts the sent messages, Failed counts the messages failed
+	// after the retries by the error kind.
+	Sent   metrics.Counter
+	Failed metrics.Counter
+	// UpdateDuration observes the handling time of updates in seconds
+	// by the update type.
+	UpdateDuration metrics.Histogram
+	// SendOnly creates the bot for one-off commands, it neither receives
+	// updates nor touches the webhook and the command list, Run must not
+	// be called.
+	SendOnly bool
+}
+
+// Limiter blocks until a message may be sent to the chat.
+type Limiter interface {
+	Wait(chatID int64)
+	// Pause stops all the messages for the duration, it is called when
+	// Telegram asks to retry later.
+	Pause(d time.Duration)
+}
+
+type nopLimiter struct{}
+
+func (nopLimiter) Wait(int64) {}
+
+// Pause blocks only the caller, nothing else is limited.
+func (nopLimiter) Pause(d time.Duration) {
+	time.Sleep(d)
 }
 
 type SNBot struct {
 	cfg *Config
-	bot *tgbotapi.BotAPI
+	bot Messenger
 	upd tgbotapi.UpdatesChannel
+	// hook receives updates posted to the webhook.
+	hook chan tgbotapi.Update
+
+	pager      *pager
+	router     *router
+	dispatcher *dispatcher
+	format     *formatter
+	// alerts limits the alerts about failed messages.
+	alerts *throttle
+
+	mu sync.Mutex
+	// getMe is when the Bot API answered getMe the last time.
+	getMe time.Time
 }
 
 func New(cfg *Config) (*SNBot, error) {
-	bot, err := tgbotapi.NewBotAPI(cfg.Token)
-	if err != nil {
-		return nil, err
+	if cfg.Limiter == nil {
+		cfg.Limiter = nopLimiter{}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Sent == nil {
+		cfg.Sent = discard.NewCounter()
+	}
+	if cfg.Failed == nil {
+		cfg.Failed = discard.NewCounter()
 	}
-	level.Info(cfg.Logger).Log("msg", "Authorized on account", "bot-name", bot.Self.UserName)
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = cfg.UpdateTime
-	updates, err := bot.GetUpdatesChan(u)
+	if cfg.UpdateDuration == nil {
+		cfg.UpdateDuration = discard.NewHistogram()
+	}
+	for i := range cfg.Channels {
+		err := cfg.Channels[i].init()
+		if err != nil {
+			return nil, err
+		}
+	}
+	format, err := newFormatter(cfg.Templates, cfg.LinkPreview)
 	if err != nil {
 		return nil, err
 	}
-	return &SNBot{
+	bot := cfg.Messenger
+	if bot == nil {
+		var err error
+		bot, err = NewTelegram(cfg.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	level.Info(cfg.Logger).Log("msg", "Authorized on account", "bot-name", bot.Self().UserName)
+	s := &SNBot{
 		cfg: cfg,
 		bot: bot,
-		upd: updates,
-	}, nil
-}
+		// the client calls getMe on creation.
+		getMe: time.Now(),
 
-type reqType int
-
-const (
-	Command reqType = 0
-	Daily   reqType = 1
-)
-
-func (s *SNBot) SendCoupons(chatID int64, cmdArgs string, t reqType) error {
-	var count int64 = 5
-	var msg string
-	if strings.TrimSpace(cmdArgs) != "" {
-		ss := strings.Split(cmdArgs, " ")
-		c, err := strconv.ParseInt(ss[0], 10, 64)
-		if err == nil {
-			count = c
+		pager:  newPager(),
+		router: newRouter(),
+		format: format,
+		alerts: newThrottle(alertInterval),
+	}
+	s.dispatcher = newDispatcher(cfg.Logger, cfg.Workers, cfg.QueueSize, s.Handle)
+	s.registerCommands()
+	err = s.seedOwners()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SendOnly {
+		return s, nil
+	}
+	err = s.setMyCommands()
+	if err != nil {
+		level.Error(cfg.Logger).Log("msg", "failed set bot commands", "err", err)
+	}
+	if cfg.Webhook != nil {
+		err = s.setWebhook()
+		if err != nil {
+			return nil, fmt.Errorf("failed set webhook: %v", err)
 		}
+		s.hook = make(chan tgbotapi.Update, updateBuffer)
+		s.upd = s.hook
+		level.Info(cfg.Logger).Log("msg", "receive updates with webhook", "url", cfg.Webhook.URL)
+		return s, nil
+	}
+	// getUpdates does not work while a webhook is set.
+	_, err = bot.Request("deleteWebhook", url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("failed remove webhook: %v", err)
+	}
+	s.upd, err = bot.Updates(cfg.UpdateTime)
+	if err != nil {
+		return nil, err
 	}
+	level.Info(cfg.Logger).Log("msg", "receive updates with long polling")
+	return s, nil
+}
+
+// SendCoupons sends the paginated list of not used coupons.
+func (s *SNBot) SendCoupons(chatID int64, lang locale.Lang, count int64) error {
 	records, err := s.cfg.Storage.GetNotUseCouponCount(chatID, count)
 	if err != nil {
 		return fmt.Errorf("failed get coupons: %v", err)
 	}
-	for i, rec := range records {
-		msg = fmt.Sprintf("%v%v:\t%s \nКод--->: %s\nВремя истечения: %v\nОписание: %s\n\n", msg, i+1, rec.Link, rec.Code, time.Unix(rec.Date, 0).Format("02.01.2006"), rec.Description)
+	if len(records) == 0 {
+		return s.Send(chatID, locale.T(lang, "coupons.empty"))
 	}
-	if len(msg) == 0 && t == Command {
-		msg = `Вы получили все доступные купоны на данный момент.`
-	}
-	err = s.Send(chatID, msg)
+	err = s.sendPage(chatID, lang, records, true)
 	if err != nil {
 		return err
 	}
-
 	err = s.cfg.Storage.MarkAsRead(chatID, records)
 	if err != nil {
 		return fmt.Errorf("failed marked as read: %v", err)
 	}
+	return nil
+}
+
+// EnqueueCoupons puts at most count coupons of the chat to the outbox,
+// they are marked as read once the message is delivered.
+func (s *SNBot) EnqueueCoupons(chatID, count int64) error {
+	pending, err := s.cfg.Storage.HasPending(chatID)
+	if err != nil {
+		return fmt.Errorf("failed check outbox: %v", err)
+	}
+	if pending {
+		return nil
+	}
+	records, err := s.cfg.Storage.GetNotUseCouponCount(chatID, count)
+	if err != nil {
+		return fmt.Errorf("failed get coupons: %v", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	// long lists are split to several messages, each marks its own
+	// coupons as read once delivered.
+	l := &pageList{records: records, lang: s.lang(chatID, nil)}
+	s.paginate(l)
+	var footer string
 	cc, err := s.cfg.Storage.CountNotUseCoupon(chatID)
 	if err != nil {
 		level.Error(s.cfg.Logger).Log("msg", "failed get count coupons", "chatID", chatID, "err", err)
-	} else if cc != 0 {
-		msg := fmt.Sprintf("Купоны оставшиеся в базе: %v", cc)
-		err = s.Send(chatID, msg)
+	} else if remain := int64(cc) - int64(len(records)); remain > 0 {
+		footer = html.EscapeString(locale.N(l.lang, "coupons.remain", int(remain), remain))
+	}
+	for l.page = 0; l.page < l.pages(); l.page++ {
+		from, to := l.span()
+		msg := strings.Join(l.items[from:to], "")
+		if l.page == l.pages()-1 {
+			msg += footer
+		}
+		ids := make([]string, 0, to-from)
+		for _, rec := range records[from:to] {
+			ids = append(ids, rec.ID)
+		}
+		markup, err := json.Marshal(couponKeyboard(records[from:to], from))
+		if err != nil {
+			return fmt.Errorf("failed marshal keyboard: %v", err)
+		}
+		err = s.cfg.Storage.Enqueue(model.OutboxMessage{
+			ChatID:    chatID,
+			Text:      strings.TrimSpace(msg),
+			Markup:    string(markup),
+			ParseMode: tgbotapi.ModeHTML,
+			Records:   strings.Join(ids, ","),
+		})
 		if err != nil {
-			return err
+			return fmt.Errorf("failed enqueue message: %v", err)
 		}
 	}
 	return nil
 }
 
-func (s *SNBot) read(message *tgbotapi.Message) error {
-	var msg string
-	switch message.Command() {
-	case "start":
-		err := s.cfg.Storage.NewChat(message.Chat)
+// Deliver sends the message from the outbox, the chunks of a long message
+// delivered by the previous attempts are skipped.
+func (s *SNBot) Deliver(om model.OutboxMessage) (model.Delivery, error) {
+	d := model.Delivery{ChatID: om.ChatID, Chunks: om.Chunks}
+	m := tgbotapi.NewMessage(om.ChatID, om.Text)
+	if om.Channel != "" {
+		m = tgbotapi.NewMessageToChannel(om.Channel, om.Text)
+	}
+	if om.ParseMode != "" {
+		m = s.format.message(m)
+		m.ParseMode = om.ParseMode
+	}
+	if om.Markup != "" {
+		var kb tgbotapi.InlineKeyboardMarkup
+		err := json.Unmarshal([]byte(om.Markup), &kb)
 		if err != nil {
-			return fmt.Errorf("failed create new chat: %v", err)
+			return d, &APIError{Description: fmt.Sprintf("failed unmarshal keyboard: %v", err), Kind: ErrBadRequest}
 		}
-		msg = info
-		s.Send(message.Chat.ID, msg)
-	case "print":
-		err := s.SendCoupons(message.Chat.ID, message.CommandArguments(), Command)
-		if err != nil {
-			return err
+		m.ReplyMarkup = kb
+	}
+	return s.sendLong(m, om.Chunks)
+}
+
+func (s *SNBot) read(message *tgbotapi.Message) error {
+	ok, err := s.service(message)
+	if ok || err != nil {
+		return err
+	}
+	if isGroup(message) && (!message.IsCommand() || !s.addressed(message)) {
+		return nil
+	}
+	if !message.IsCommand() {
+		return s.Send(message.Chat.ID, locale.T(s.lang(message.Chat.ID, message.From), "hint"))
+	}
+	return s.router.dispatch(message)
+}
+
+// Run processes updates until the context is done or the updates stream
+// is closed, the queued updates are handled before it returns.
+func (s *SNBot) Run(ctx context.Context) error {
+	errc := make(chan error, 1)
+	if s.cfg.Webhook != nil {
+		go func() {
+			errc <- s.serveWebhook(ctx)
+		}()
+	}
+	var (
+		err  error
+		done = ctx.Done()
+	)
+loop:
+	for {
+		select {
+		case u, ok := <-s.upd:
+			if !ok {
+				break loop
+			}
+			s.dispatcher.dispatch(u)
+		case err = <-errc:
+			s.flushUpdates()
+			break loop
+		case <-done:
+			if s.cfg.Webhook == nil {
+				s.bot.StopUpdates()
+				s.flushUpdates()
+				break loop
+			}
+			// keep reading until the webhook server finishes its requests.
+			done = nil
 		}
-	case "stat":
-		err := s.SendStat(message.Chat.ID, message.CommandArguments())
-		if err != nil {
-			return err
+	}
+	s.dispatcher.drain()
+	level.Info(s.cfg.Logger).Log("msg", "updates drained", "processed", s.dispatcher.stats().Processed)
+	return err
+}
+
+// flushUpdates dispatches the updates already received.
+func (s *SNBot) flushUpdates() {
+	for {
+		select {
+		case u, ok := <-s.upd:
+			if !ok {
+				return
+			}
+			s.dispatcher.dispatch(u)
+		default:
+			return
 		}
-	default:
-		msg = info
-		s.Send(message.Chat.ID, msg)
 	}
-	return nil
 }
 
-func (s *SNBot) Run() {
-	for u := range s.upd {
-		if u.Message == nil {
-			continue
+// DispatchStats returns the counters of the update workers.
+func (s *SNBot) DispatchStats() DispatchStats {
+	return s.dispatcher.stats()
+}
+
+// Handle processes a single update.
+func (s *SNBot) Handle(u tgbotapi.Update) {
+	defer func(begin time.Time) {
+		s.cfg.UpdateDuration.With("type", updateType(u)).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	if u.CallbackQuery != nil {
+		err := s.callback(u.CallbackQuery)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed handle callback", "data", u.CallbackQuery.Data, "err", err)
 		}
-		err := s.read(u.Message)
+		return
+	}
+	if u.InlineQuery != nil {
+		err := s.inline(u.InlineQuery)
 		if err != nil {
-			level.Error(s.cfg.Logger).Log("msg", "failed read message", "err", err)
-			s.Send(u.Message.Chat.ID, "service temporary unavailable")
+			level.Error(s.cfg.Logger).Log("msg", "failed answer inline query", "query", u.InlineQuery.Query, "err", err)
 		}
+		return
+	}
+	if u.Message == nil {
+		return
+	}
+	err := s.read(u.Message)
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed read message", "err", err)
+		s.Send(u.Message.Chat.ID, locale.T(s.lang(u.Message.Chat.ID, u.Message.From), "unavailable"))
+	}
+}
 
+// CheckTelegram calls getMe unless it succeeded within maxAge,
+// it reports whether the Bot API is reachable with the token.
+func (s *SNBot) CheckTelegram(maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.getMe) < maxAge {
+		return nil
+	}
+	_, err := s.bot.Request("getMe", nil)
+	if err != nil {
+		return fmt.Errorf("failed get me: %v", err)
 	}
+	s.getMe = time.Now()
+	return nil
+}
+
+// updateType names the update in metrics.
+func updateType(u tgbotapi.Update) string {
+	switch {
+	case u.CallbackQuery != nil:
+		return "callback_query"
+	case u.InlineQuery != nil:
+		return "inline_query"
+	case u.Message != nil:
+		return "message"
+	}
+	return "other"
 }
 
 func (s *SNBot) Send(chatID int64, msg string) error {
@@ -164,28 +459,117 @@ func (s *SNBot) Send(chatID int64, msg string) error {
 	)
 	m := tgbotapi.NewMessage(chatID, msg)
 	m.ReplyMarkup = numericKeyboard
-	_, err := s.bot.Send(m)
-	if err != nil {
-		if err.Error() == errBlockedByUser {
-			s.cfg.Storage.UpdChatActivity(chatID, false)
+	_, err := s.sendLong(m, 0)
+	return err
+}
+
+// sendLong sends the text longer than messageLimit in several messages,
+// the markup is attached to the last one. The first skip chunks are not
+// sent, the delivery counts the chunks sent including the skipped ones.
+func (s *SNBot) sendLong(m tgbotapi.MessageConfig, skip int) (model.Delivery, error) {
+	chunks := splitMessage(m.Text, messageLimit, m.ParseMode == tgbotapi.ModeHTML)
+	markup := m.ReplyMarkup
+	d := model.Delivery{ChatID: m.ChatID, Chunks: skip}
+	for ; d.Chunks < len(chunks); d.Chunks++ {
+		c := m
+		c.ChatID = d.ChatID
+		c.Text = chunks[d.Chunks]
+		c.ReplyMarkup = nil
+		if d.Chunks == len(chunks)-1 {
+			c.ReplyMarkup = markup
+		}
+		var err error
+		_, d.ChatID, err = s.sendChat(c)
+		if err != nil {
+			return d, err
 		}
-		return err
 	}
-	return nil
+	return d, nil
 }
 
-func (s *SNBot) SendStat(chatID int64, args string) error {
-	if strings.TrimSpace(args) != "" {
-		ss := strings.Split(args, " ")
-		token := ss[0]
-		if s.cfg.AccessToken != token {
-			return errors.New("failed token")
+// maxRetries is how many times a message is resent after a flood wait
+// or a chat migration.
+const maxRetries = 3
+
+// send sends the message, the error is always *APIError.
+func (s *SNBot) send(m tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	msg, _, err := s.sendChat(m)
+	return msg, err
+}
+
+// sendChat sends the message like send and returns the chat it was sent
+// to, the new one if the chat migrated.
+func (s *SNBot) sendChat(m tgbotapi.MessageConfig) (tgbotapi.Message, int64, error) {
+	var (
+		msg tgbotapi.Message
+		e   *APIError
+	)
+	for i := 0; i <= maxRetries; i++ {
+		s.cfg.Limiter.Wait(m.ChatID)
+		var err error
+		msg, err = s.bot.Send(m)
+		e = classify(err)
+		if e == nil {
+			s.cfg.Sent.Add(1)
+			return msg, m.ChatID, nil
+		}
+		level.Warn(s.cfg.Logger).Log("msg", "failed send message", "chatID", m.ChatID, "kind", e.Kind, "code", e.Code, "err", e.Description)
+		if e.Kind == ErrFlood {
+			// the limit is shared by all the chats, the next Wait blocks
+			// until the pause ends.
+			s.cfg.Limiter.Pause(e.RetryAfter)
+			continue
+		}
+		if m.ChatID == 0 {
+			break
+		}
+		to := s.handleError(m.ChatID, e)
+		if to == 0 {
+			break
 		}
-		count, err := s.cfg.Storage.GetCountUser()
+		m.ChatID = to
+	}
+	s.cfg.Failed.With("kind", e.Kind.String()).Add(1)
+	return msg, m.ChatID, e
+}
+
+// vote stores the chat's feedback and hides the coupon once its score
+// falls to HideScore.
+func (s *SNBot) vote(chatID int64, id string, vote int) error {
+	score, err := s.cfg.Storage.Vote(chatID, id, vote)
+	if err != nil {
+		return fmt.Errorf("failed vote: %v", err)
+	}
+	if score > s.cfg.HideScore {
+		return nil
+	}
+	hidden, err := s.cfg.Storage.HideRecord(id)
+	if err != nil {
+		return fmt.Errorf("failed hide record: %v", err)
+	}
+	if hidden {
+		rec, err := s.cfg.Storage.GetRecord(id)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed get record: %v", err)
 		}
-		s.Send(chatID, fmt.Sprintf("Активных пользователей в базе: %d", count))
+		level.Info(s.cfg.Logger).Log("msg", "record hidden by votes", "id", id, "score", score)
+		s.alert("alert.hidden", score, rec.Link, rec.Code)
 	}
 	return nil
 }
+
+// alert notifies admins about service events with the catalog message.
+func (s *SNBot) alert(key string, args ...interface{}) {
+	aa, err := s.cfg.Storage.GetAdmins(model.RoleAdmin)
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed get admins", "err", err)
+		return
+	}
+	for _, a := range aa {
+		s.cfg.Limiter.Wait(a.UserID)
+		_, err := s.bot.Send(tgbotapi.NewMessage(a.UserID, locale.T(s.lang(a.UserID, nil), key, args...)))
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed send alert", "chatID", a.UserID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package snbot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/broadcast"
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+	"github.com/wenkaler/xfreehack/outbox"
+	"github.com/wenkaler/xfreehack/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const testChat = 100
+
+// collectCoupons stores n coupons expiring in a week.
+func collectCoupons(t *testing.T, s *storage.Storage, n int) {
+	for i := 1; i <= n; i++ {
+		_, err := s.Collect(collector.Record{
+			Code:        fmt.Sprintf("CODE%d", i),
+			Description: fmt.Sprintf("coupon %d", i),
+			Link:        fmt.Sprintf("https://example.com/%d", i),
+			Date:        time.Now().AddDate(0, 0, 7).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// flushOutbox delivers the due messages of the outbox with the bot.
+func flushOutbox(t *testing.T, sn *SNBot, s *storage.Storage) {
+	b, err := broadcast.New(&broadcast.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := outbox.New(&outbox.Config{Storage: s, Messenger: sn, Broadcaster: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countNotUsed(t *testing.T, s *storage.Storage) uint64 {
+	n, err := s.CountNotUseCoupon(testChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStart(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	sn.Handle(textMessage(testChat, "/start"))
+
+	rr := api.sent("sendMessage")
+	if len(rr) != 1 {
+		t.Fatalf("sendMessage requests = %v, want 1", rr)
+	}
+	if got, want := rr[0].Params.Get("text"), locale.T(locale.English, "info"); got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	cc, err := s.ListChats(true, -1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc) != 1 || cc[0].ID != testChat || !cc[0].Active {
+		t.Errorf("chats = %+v, want the active chat %d", cc, testChat)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	collectCoupons(t, s, 7)
+	sn.Handle(textMessage(testChat, "/print"))
+
+	rr := api.sent("sendMessage")
+	if len(rr) != 1 {
+		t.Fatalf("sendMessage requests = %v, want 1", rr)
+	}
+	if rr[0].Params.Get("parse_mode") != tgbotapi.ModeHTML {
+		t.Errorf("parse_mode = %q, want HTML", rr[0].Params.Get("parse_mode"))
+	}
+	text := rr[0].Params.Get("text")
+	for i := 1; i <= 5; i++ {
+		if !strings.Contains(text, fmt.Sprintf("CODE%d", i)) {
+			t.Errorf("text %q has no CODE%d", text, i)
+		}
+	}
+	if !strings.Contains(rr[0].Params.Get("reply_markup"), `"callback_data":"more"`) {
+		t.Errorf("reply_markup %s has no more button", rr[0].Params.Get("reply_markup"))
+	}
+	if footer := locale.N(locale.English, "coupons.remain", 2, 2); !strings.HasSuffix(text, footer) {
+		t.Errorf("text %q has no footer %q", text, footer)
+	}
+	if n := countNotUsed(t, s); n != 2 {
+		t.Fatalf("not used coupons = %d, want 2", n)
+	}
+
+	// the rest is loaded to the same message.
+	sn.Handle(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "q1",
+		From: &tgbotapi.User{ID: testChat, LanguageCode: "en"},
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: testChat, Type: "private"},
+		},
+		Data: cbMore,
+	}})
+	rr = api.sent("editMessageText")
+	if len(rr) != 1 {
+		t.Fatalf("editMessageText requests = %v, want 1", rr)
+	}
+	if rr[0].Params.Get("message_id") != "1" || rr[0].Params.Get("chat_id") != strconv.Itoa(testChat) {
+		t.Errorf("edited message %s in chat %s, want 1 in %d", rr[0].Params.Get("message_id"), rr[0].Params.Get("chat_id"), testChat)
+	}
+	text = rr[0].Params.Get("text")
+	if !strings.Contains(text, "CODE7") {
+		t.Errorf("text %q has no CODE7", text)
+	}
+	// nothing is left after the loaded coupons.
+	if strings.Contains(text, "in the database") {
+		t.Errorf("text %q has the remain footer", text)
+	}
+	if strings.Contains(rr[0].Params.Get("reply_markup"), `"callback_data":"more"`) {
+		t.Errorf("reply_markup %s has the more button", rr[0].Params.Get("reply_markup"))
+	}
+	if n := countNotUsed(t, s); n != 0 {
+		t.Errorf("not used coupons = %d, want 0", n)
+	}
+	if len(api.sent("answerCallbackQuery")) != 1 {
+		t.Errorf("callback is not answered")
+	}
+}
+
+func TestStat(t *testing.T) {
+	const admin = 200
+	sn, api, s := newTestBot(t, &Config{SendOnly: true, Admins: []int64{admin}})
+	sn.Handle(textMessage(testChat, "/start"))
+	sn.Handle(textMessage(testChat, "/stat"))
+	sn.Handle(textMessage(admin, "/stat"))
+
+	var toUser, toAdmin []string
+	for _, r := range api.sent("sendMessage") {
+		switch r.Params.Get("chat_id") {
+		case strconv.Itoa(testChat):
+			toUser = append(toUser, r.Params.Get("text"))
+		case strconv.Itoa(admin):
+			toAdmin = append(toAdmin, r.Params.Get("text"))
+		}
+	}
+	// /stat is unknown to users without a role.
+	if len(toUser) != 2 || strings.HasPrefix(toUser[1], locale.T(locale.English, "stat.active", 1)) {
+		t.Errorf("user messages = %q, want the greeting and no stats", toUser)
+	}
+	if len(toAdmin) != 1 || !strings.HasPrefix(toAdmin[0], locale.T(locale.English, "stat.active", 1)) {
+		t.Errorf("admin messages = %q, want the stats of one active chat", toAdmin)
+	}
+	role, err := s.GetRole(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != model.RoleOwner {
+		t.Errorf("admin role = %v, want owner", role)
+	}
+}
+
+func TestDailyCoupons(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	collectCoupons(t, s, 7)
+	sn.Handle(textMessage(testChat, "/start"))
+
+	err := sn.EnqueueCoupons(testChat, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the coupons stay unread until the message is delivered.
+	if n := countNotUsed(t, s); n != 7 {
+		t.Fatalf("not used coupons = %d, want 7", n)
+	}
+	pending, err := s.HasPending(testChat)
+	if err != nil || !pending {
+		t.Fatalf("HasPending() = %t, %v, want true", pending, err)
+	}
+
+	flushOutbox(t, sn, s)
+
+	rr := api.sent("sendMessage")
+	if len(rr) != 2 {
+		t.Fatalf("sendMessage requests = %v, want the greeting and the coupons", rr)
+	}
+	// the daily message has no user to detect the language from.
+	text := rr[1].Params.Get("text")
+	if !strings.Contains(text, "CODE1") || !strings.Contains(text, locale.N(locale.Default, "coupons.remain", 2, 2)) {
+		t.Errorf("text = %q, want the coupons and the remain footer", text)
+	}
+	if n := countNotUsed(t, s); n != 2 {
+		t.Errorf("not used coupons = %d, want 2", n)
+	}
+	pending, err = s.HasPending(testChat)
+	if err != nil || pending {
+		t.Errorf("HasPending() = %t, %v, want false", pending, err)
+	}
+}
+
+func TestDeliverResumesChunks(t *testing.T) {
+	sn, api, _ := newTestBot(t, &Config{SendOnly: true})
+	om := model.OutboxMessage{ChatID: testChat, Text: strings.Repeat("word ", 1000)}
+	chunks := splitMessage(om.Text, messageLimit, false)
+	if len(chunks) != 2 {
+		t.Fatalf("message is split to %d chunks, want 2", len(chunks))
+	}
+	api.reply("sendMessage", "")
+	api.reply("sendMessage", `{"ok":false,"error_code":500,"description":"Internal Server Error"}`)
+
+	d, err := sn.Deliver(om)
+	if err == nil || d.Chunks != 1 {
+		t.Fatalf("Deliver() = %+v, %v, want 1 chunk and an error", d, err)
+	}
+	om.Chunks = d.Chunks
+	d, err = sn.Deliver(om)
+	if err != nil || d.Chunks != 2 {
+		t.Fatalf("Deliver() = %+v, %v, want 2 chunks", d, err)
+	}
+	rr := api.sent("sendMessage")
+	if len(rr) != 3 {
+		t.Fatalf("sendMessage requests = %d, want 3", len(rr))
+	}
+	// the first chunk is not sent again.
+	for i, want := range []string{chunks[0], chunks[1], chunks[1]} {
+		if got := rr[i].Params.Get("text"); got != want {
+			t.Errorf("request %d text = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDeliverMigratedChat(t *testing.T) {
+	const group, supergroup = -100, -1002
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	collectCoupons(t, s, 3)
+	err := s.NewChat(&tgbotapi.Chat{ID: group, Type: "group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sn.EnqueueCoupons(group, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.reply("sendMessage", `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1002}}`)
+
+	flushOutbox(t, sn, s)
+
+	rr := api.sent("sendMessage")
+	if len(rr) != 2 || rr[1].Params.Get("chat_id") != strconv.Itoa(supergroup) {
+		t.Fatalf("sendMessage requests = %v, want a retry to the supergroup", rr)
+	}
+	// the coupons are marked as read in the new chat.
+	n, err := s.CountNotUseCoupon(supergroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("not used coupons of the supergroup = %d, want 0", n)
+	}
+	pending, err := s.HasPending(supergroup)
+	if err != nil || pending {
+		t.Errorf("HasPending() = %t, %v, want false", pending, err)
+	}
+}
+
+func TestVoteHideScore(t *testing.T) {
+	// zero is a valid score, not the default.
+	sn, _, s := newTestBot(t, &Config{SendOnly: true})
+	collectCoupons(t, s, 1)
+	sn.Handle(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "q1",
+		From: &tgbotapi.User{ID: testChat, LanguageCode: "en"},
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: testChat, Type: "private"},
+		},
+		Data: callbackData(cbVote, "1", "-1"),
+	}})
+	_, err := s.GetCoupon("1")
+	if err != sql.ErrNoRows {
+		t.Errorf("GetCoupon() error = %v, want the coupon hidden", err)
+	}
+}
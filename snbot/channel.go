@@ -0,0 +1,127 @@
+package snbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Channel is a telegram channel every new coupon is posted to.
+type Channel struct {
+	// ID is the channel @username or its numeric id.
+	ID string `json:"id"`
+	// Filter is a regular expression the coupon code, link or description
+	// has to match, empty filter matches every coupon.
+	Filter string `json:"filter"`
+	// Format is either "full" (default) or "short".
+	Format string `json:"format"`
+	// Language of the posts, ru by default.
+	Language string `json:"language"`
+
+	re   *regexp.Regexp
+	lang locale.Lang
+	// chatID is the numeric ID, zero for a @username.
+	chatID int64
+}
+
+const (
+	FormatFull  = "full"
+	FormatShort = "short"
+)
+
+func (c *Channel) init() error {
+	if c.ID == "" {
+		return fmt.Errorf("channel id is empty")
+	}
+	if !strings.HasPrefix(c.ID, "@") {
+		id, err := strconv.ParseInt(c.ID, 10, 64)
+		if err != nil || id == 0 {
+			return fmt.Errorf("channel %s: id is neither @username nor a number", c.ID)
+		}
+		c.chatID = id
+	}
+	switch c.Format {
+	case "":
+		c.Format = FormatFull
+	case FormatFull, FormatShort:
+	default:
+		return fmt.Errorf("channel %s: unknown format %q", c.ID, c.Format)
+	}
+	c.lang = locale.Default
+	if c.Language != "" {
+		l, ok := locale.Parse(c.Language)
+		if !ok {
+			return fmt.Errorf("channel %s: unknown language %q", c.ID, c.Language)
+		}
+		c.lang = l
+	}
+	if c.Filter != "" {
+		re, err := regexp.Compile(c.Filter)
+		if err != nil {
+			return fmt.Errorf("channel %s: failed compile filter: %v", c.ID, err)
+		}
+		c.re = re
+	}
+	return nil
+}
+
+func (c *Channel) match(rec collector.Record) bool {
+	if c.re == nil {
+		return true
+	}
+	return c.re.MatchString(rec.Code) || c.re.MatchString(rec.Link) || c.re.MatchString(rec.Description)
+}
+
+// post returns the outbox message of the coupon in the channel format.
+func (s *SNBot) post(c *Channel, rec collector.Record) model.OutboxMessage {
+	m := model.OutboxMessage{
+		ChatID:    c.chatID,
+		Text:      s.format.coupon(c.lang, rec),
+		ParseMode: tgbotapi.ModeHTML,
+	}
+	if c.chatID == 0 {
+		m.Channel = c.ID
+	}
+	if c.Format == FormatShort {
+		m.Text = s.format.couponShort(c.lang, rec)
+	}
+	return m
+}
+
+// Publish puts the coupons not yet posted to every configured channel
+// to the outbox.
+func (s *SNBot) Publish() error {
+	for i := range s.cfg.Channels {
+		c := &s.cfg.Channels[i]
+		records, err := s.cfg.Storage.GetNotPosted(c.ID, c.Filter)
+		if err != nil {
+			return fmt.Errorf("failed get not posted coupons: %v", err)
+		}
+		var posted int
+		for _, rec := range records {
+			if !c.match(rec) {
+				// the coupon is not scanned again until the filter is changed.
+				err = s.cfg.Storage.SkipPost(c.ID, c.Filter, rec.ID)
+				if err != nil {
+					return fmt.Errorf("failed skip post: %v", err)
+				}
+				continue
+			}
+			err = s.cfg.Storage.EnqueuePost(c.ID, rec.ID, s.post(c, rec))
+			if err != nil {
+				return fmt.Errorf("failed enqueue post: %v", err)
+			}
+			posted++
+		}
+		level.Info(s.cfg.Logger).Log("msg", "enqueued coupons to channel", "channel", c.ID, "count", posted)
+	}
+	return nil
+}
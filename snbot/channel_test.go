@@ -0,0 +1,95 @@
+package snbot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestChannelInit(t *testing.T) {
+	tests := []struct {
+		id     string
+		chatID int64
+		ok     bool
+	}{
+		{id: "@coupons", ok: true},
+		{id: "-1001234567890", chatID: -1001234567890, ok: true},
+		{id: ""},
+		{id: "coupons"},
+		{id: "0"},
+		{id: "-100x"},
+	}
+	for _, tt := range tests {
+		c := Channel{ID: tt.id}
+		err := c.init()
+		if (err == nil) != tt.ok {
+			t.Errorf("init(%q) = %v, want ok %t", tt.id, err, tt.ok)
+			continue
+		}
+		if c.chatID != tt.chatID {
+			t.Errorf("init(%q) chat id = %d, want %d", tt.id, c.chatID, tt.chatID)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true, Channels: []Channel{
+		{ID: "@coupons"},
+		{ID: "-1001", Format: FormatShort, Filter: "CODE2"},
+	}})
+	collectCoupons(t, s, 2)
+	err := sn.Publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing is sent until the outbox is flushed.
+	if rr := api.sent("sendMessage"); len(rr) != 0 {
+		t.Fatalf("sendMessage requests = %v, want none", rr)
+	}
+	for _, c := range sn.cfg.Channels {
+		rr, err := s.GetNotPosted(c.ID, c.Filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rr) != 0 {
+			t.Errorf("not posted coupons of %s = %+v, want none", c.ID, rr)
+		}
+	}
+	flushOutbox(t, sn, s)
+
+	got := make(map[string]int)
+	for _, r := range api.sent("sendMessage") {
+		got[r.Params.Get("chat_id")]++
+		if r.Params.Get("parse_mode") != tgbotapi.ModeHTML {
+			t.Errorf("post %q is not HTML", r.Params.Get("text"))
+		}
+	}
+	if got["@coupons"] != 2 || got["-1001"] != 1 || len(got) != 2 {
+		t.Errorf("posts by channel = %v, want 2 to @coupons and 1 to -1001", got)
+	}
+
+	err = sn.Publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := s.CountPending()
+	if err != nil || pending != 0 {
+		t.Errorf("CountPending() = %d, %v, want nothing posted twice", pending, err)
+	}
+
+	// the skipped coupon is posted once the filter matches it.
+	c := &sn.cfg.Channels[1]
+	c.Filter = "CODE"
+	err = c.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sn.Publish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = s.CountPending()
+	if err != nil || pending != 1 {
+		t.Errorf("CountPending() = %d, %v, want the skipped coupon", pending, err)
+	}
+}
@@ -0,0 +1,109 @@
+package snbot
+
+import (
+	"fmt"
+
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+)
+
+// registerCommands fills the router with the bot commands.
+func (s *SNBot) registerCommands() {
+	r := s.router
+	r.Use(s.recovery, s.logging, s.language, s.rateLimit, s.usage, s.auth)
+	r.unknown = s.unknownCommand
+	r.Register(Command{
+		Name:        "start",
+		Description: "cmd.start",
+		Settings:    true,
+		Handler: func(c *Context) error {
+			err := s.cfg.Storage.NewChat(c.Message.Chat)
+			if err != nil {
+				return fmt.Errorf("failed create new chat: %v", err)
+			}
+			return s.Send(c.ChatID(), locale.T(c.Lang, "info"))
+		},
+	})
+	r.Register(Command{
+		Name:        "stop",
+		Description: "cmd.stop",
+		Settings:    true,
+		Handler: func(c *Context) error {
+			err := s.cfg.Storage.DeactivateChat(c.ChatID(), "stopped by user")
+			if err != nil {
+				return fmt.Errorf("failed deactivate chat: %v", err)
+			}
+			return s.Send(c.ChatID(), locale.T(c.Lang, "stopped"))
+		},
+	})
+	r.Register(Command{
+		Name:        "print",
+		Description: "cmd.print",
+		Usage:       "usage.print",
+		Args:        intArg(5, 100),
+		Handler: func(c *Context) error {
+			return s.SendCoupons(c.ChatID(), c.Lang, c.Args.(int64))
+		},
+	})
+	r.Register(Command{
+		Name:        "history",
+		Description: "cmd.history",
+		Usage:       "usage.history",
+		Args:        intArg(10, 50),
+		Handler: func(c *Context) error {
+			return s.SendHistory(c.ChatID(), c.Lang, c.Args.(int64))
+		},
+	})
+	r.Register(Command{
+		Name:        "saved",
+		Description: "cmd.saved",
+		Handler: func(c *Context) error {
+			return s.SendSaved(c.ChatID(), c.Lang)
+		},
+	})
+	r.Register(Command{
+		Name:        "help",
+		Description: "cmd.help",
+		Handler:     s.help,
+	})
+	r.Register(Command{
+		Name:        "language",
+		Description: "cmd.language",
+		Settings:    true,
+		Handler:     s.setLanguage,
+	})
+	r.Register(Command{
+		Name:        "stat",
+		Description: "cmd.stat",
+		Usage:       "usage.stat",
+		Args:        intArg(statDays, maxStatDays),
+		Role:        model.RoleViewer,
+		Handler: func(c *Context) error {
+			return s.SendStat(c.ChatID(), c.Lang, int(c.Args.(int64)))
+		},
+	})
+	r.Register(Command{
+		Name:        "announce",
+		Description: "cmd.announce",
+		Role:        model.RoleAdmin,
+		Handler: func(c *Context) error {
+			return s.Announce(c.Message, c.Lang)
+		},
+	})
+	r.Register(Command{
+		Name:        "announcements",
+		Description: "cmd.announcements",
+		Role:        model.RoleAdmin,
+		Handler: func(c *Context) error {
+			return s.SendAnnouncements(c.ChatID(), c.Lang)
+		},
+	})
+	r.Register(Command{
+		Name:        "admin",
+		Description: "cmd.admin",
+		Role:        model.RoleOwner,
+		Handler: func(c *Context) error {
+			return s.ManageAdmins(c.Message, c.Lang)
+		},
+	})
+}
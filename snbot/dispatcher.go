@@ -0,0 +1,114 @@
+package snbot
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// DispatchStats are the counters of the update worker pool.
+type DispatchStats struct {
+	// Queued is the number of updates waiting in the queues.
+	Queued int64
+	// Processed is the number of handled updates.
+	Processed uint64
+	// Blocked is the number of updates which waited for a full queue.
+	Blocked uint64
+	// BlockedTime is the total time spent waiting for full queues.
+	BlockedTime time.Duration
+}
+
+// dispatcher processes updates concurrently, updates of a single chat
+// always go to the same worker and are handled in order.
+type dispatcher struct {
+	// counters go first to be 64-bit aligned for atomic operations.
+	queued      int64
+	processed   uint64
+	blocked     uint64
+	blockedTime int64
+
+	logger log.Logger
+	handle func(tgbotapi.Update)
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(logger log.Logger, workers, queueSize int, handle func(tgbotapi.Update)) *dispatcher {
+	d := &dispatcher{
+		logger: logger,
+		handle: handle,
+		queues: make([]chan tgbotapi.Update, workers),
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan tgbotapi.Update, queueSize)
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+func (d *dispatcher) work(q chan tgbotapi.Update) {
+	defer d.wg.Done()
+	for u := range q {
+		atomic.AddInt64(&d.queued, -1)
+		d.handle(u)
+		atomic.AddUint64(&d.processed, 1)
+	}
+}
+
+// dispatch queues the update, it blocks while the queue of the chat is full.
+func (d *dispatcher) dispatch(u tgbotapi.Update) {
+	q := d.queues[uint64(updateChat(u))%uint64(len(d.queues))]
+	atomic.AddInt64(&d.queued, 1)
+	select {
+	case q <- u:
+		return
+	default:
+	}
+	begin := time.Now()
+	q <- u
+	elapsed := time.Since(begin)
+	atomic.AddUint64(&d.blocked, 1)
+	atomic.AddInt64(&d.blockedTime, int64(elapsed))
+	level.Warn(d.logger).Log("msg", "update queue is full", "chatID", updateChat(u), "time elapsed", elapsed)
+}
+
+// drain waits until all queued updates are handled, no updates may be
+// dispatched after it.
+func (d *dispatcher) drain() {
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.wg.Wait()
+}
+
+func (d *dispatcher) stats() DispatchStats {
+	return DispatchStats{
+		Queued:      atomic.LoadInt64(&d.queued),
+		Processed:   atomic.LoadUint64(&d.processed),
+		Blocked:     atomic.LoadUint64(&d.blocked),
+		BlockedTime: time.Duration(atomic.LoadInt64(&d.blockedTime)),
+	}
+}
+
+// updateChat returns the chat the update belongs to, inline queries
+// have no chat and are ordered by the user.
+func updateChat(u tgbotapi.Update) int64 {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.ID
+	case u.EditedMessage != nil:
+		return u.EditedMessage.Chat.ID
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		return u.CallbackQuery.Message.Chat.ID
+	case u.CallbackQuery != nil:
+		return int64(u.CallbackQuery.From.ID)
+	case u.InlineQuery != nil:
+		return int64(u.InlineQuery.From.ID)
+	}
+	return 0
+}
@@ -0,0 +1,186 @@
+package snbot
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// ErrorKind is the class of a failed Telegram API request.
+type ErrorKind int
+
+const (
+	ErrUnknown ErrorKind = iota
+	// ErrNetwork is a failure to reach the API at all.
+	ErrNetwork
+	ErrBlocked
+	ErrUserDeactivated
+	ErrChatNotFound
+	ErrKicked
+	ErrMigrated
+	ErrFlood
+	ErrBadRequest
+	ErrUnauthorized
+	ErrServer
+	// ErrForbidden is any other refusal to send to the chat.
+	ErrForbidden
+)
+
+var kindText = map[ErrorKind]string{
+	ErrUnknown:         "unknown",
+	ErrNetwork:         "network",
+	ErrBlocked:         "blocked",
+	ErrUserDeactivated: "user_deactivated",
+	ErrChatNotFound:    "chat_not_found",
+	ErrKicked:          "kicked",
+	ErrMigrated:        "migrated",
+	ErrFlood:           "flood",
+	ErrBadRequest:      "bad_request",
+	ErrUnauthorized:    "unauthorized",
+	ErrServer:          "server",
+	ErrForbidden:       "forbidden",
+}
+
+func (k ErrorKind) String() string {
+	return kindText[k]
+}
+
+// APIError is a classified Telegram API error.
+type APIError struct {
+	// Code is the error_code of the response, zero when the response
+	// was not received or the Messenger does not report it.
+	Code        int
+	Description string
+	Kind        ErrorKind
+	// MigrateTo is the new id of a group upgraded to a supergroup.
+	MigrateTo  int64
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return e.Description
+}
+
+// Permanent reports whether resending the request will fail again.
+func (e *APIError) Permanent() bool {
+	switch e.Kind {
+	case ErrBlocked, ErrUserDeactivated, ErrChatNotFound, ErrKicked, ErrForbidden, ErrBadRequest:
+		return true
+	}
+	return false
+}
+
+// Inactive reports whether the chat can not receive messages anymore,
+// that is every 403 response.
+func (e *APIError) Inactive() bool {
+	switch e.Kind {
+	case ErrBlocked, ErrUserDeactivated, ErrChatNotFound, ErrKicked, ErrForbidden:
+		return true
+	}
+	return false
+}
+
+// classify converts an error returned by the Telegram client to *APIError.
+func classify(err error) *APIError {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*APIError); ok {
+		return e
+	}
+	var params tgbotapi.ResponseParameters
+	e := &APIError{}
+	switch re := err.(type) {
+	case *ResponseError:
+		e.Code, e.Description, params = re.Code, re.Description, re.ResponseParameters
+	case tgbotapi.Error:
+		e.Description, params = re.Message, re.ResponseParameters
+	default:
+		return &APIError{Description: err.Error(), Kind: ErrNetwork}
+	}
+	e.MigrateTo = params.MigrateToChatID
+	e.RetryAfter = time.Duration(params.RetryAfter) * time.Second
+	desc := strings.ToLower(e.Description)
+	switch {
+	case e.MigrateTo != 0:
+		e.Kind = ErrMigrated
+	case e.RetryAfter != 0 || e.Code == 429:
+		e.Kind = ErrFlood
+	case strings.Contains(desc, "bot was blocked by the user"):
+		e.Kind = ErrBlocked
+	case strings.Contains(desc, "user is deactivated"):
+		e.Kind = ErrUserDeactivated
+	case strings.Contains(desc, "chat not found"):
+		e.Kind = ErrChatNotFound
+	case strings.Contains(desc, "bot was kicked"), strings.Contains(desc, "bot is not a member"):
+		e.Kind = ErrKicked
+	case e.Code == 403:
+		e.Kind = ErrForbidden
+	case e.Code == 400:
+		e.Kind = ErrBadRequest
+	case e.Code == 401:
+		e.Kind = ErrUnauthorized
+	case e.Code >= 500:
+		e.Kind = ErrServer
+	}
+	return e
+}
+
+// handleError reacts to a failed request to the chat: deactivates or migrates
+// the chat, or alerts admins about unexpected failures.
+// It returns the id of the migrated chat or zero.
+func (s *SNBot) handleError(chatID int64, e *APIError) int64 {
+	switch {
+	case e.Inactive():
+		err := s.cfg.Storage.DeactivateChat(chatID, e.Description)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed deactivate chat", "chatID", chatID, "err", err)
+		}
+	case e.Kind == ErrMigrated:
+		err := s.migrate(chatID, e.MigrateTo)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed migrate chat", "chatID", chatID, "err", err)
+			return 0
+		}
+		return e.MigrateTo
+	case e.Kind == ErrBadRequest, e.Kind == ErrUnauthorized, e.Kind == ErrUnknown:
+		// a broken message fails for every chat, it is reported once.
+		if s.alerts.allow(e.Kind.String() + ": " + e.Description) {
+			s.alert("alert.send_failed", chatID, e.Description)
+		}
+	}
+	return 0
+}
+
+// alertInterval is how often admins are alerted about the same failure.
+const alertInterval = time.Hour
+
+// throttle allows an action once per interval by the key.
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newThrottle(interval time.Duration) *throttle {
+	return &throttle{interval: interval, last: make(map[string]time.Time)}
+}
+
+func (t *throttle) allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	for k, last := range t.last {
+		if now.Sub(last) >= t.interval {
+			delete(t.last, k)
+		}
+	}
+	t.last[key] = now
+	return true
+}
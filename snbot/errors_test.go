@@ -0,0 +1,106 @@
+package snbot
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		response string
+		want     APIError
+	}{
+		{
+			response: `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			want:     APIError{Code: 403, Kind: ErrBlocked},
+		},
+		{
+			response: `{"ok":false,"error_code":403,"description":"Forbidden: bot can't initiate conversation with a user"}`,
+			want:     APIError{Code: 403, Kind: ErrForbidden},
+		},
+		{
+			response: `{"ok":false,"error_code":403,"description":"Forbidden: bot can't send messages to bots"}`,
+			want:     APIError{Code: 403, Kind: ErrForbidden},
+		},
+		{
+			response: `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+			want:     APIError{Code: 400, Kind: ErrChatNotFound},
+		},
+		{
+			response: `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`,
+			want:     APIError{Code: 400, Kind: ErrBadRequest},
+		},
+		{
+			response: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`,
+			want:     APIError{Code: 429, Kind: ErrFlood, RetryAfter: 5 * time.Second},
+		},
+		{
+			response: `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1001}}`,
+			want:     APIError{Code: 400, Kind: ErrMigrated, MigrateTo: -1001},
+		},
+		{
+			// the code is taken from the response, not the description.
+			response: `{"ok":false,"error_code":502,"description":"Gateway problem"}`,
+			want:     APIError{Code: 502, Kind: ErrServer},
+		},
+		{
+			response: `{"ok":false,"error_code":401,"description":"Unauthorized"}`,
+			want:     APIError{Code: 401, Kind: ErrUnauthorized},
+		},
+	}
+	sn, api, _ := newTestBot(t, &Config{SendOnly: true})
+	for _, tt := range tests {
+		api.reply("sendMessage", tt.response)
+		_, err := sn.bot.Send(tgbotapi.NewMessage(testChat, "text"))
+		e := classify(err)
+		if e == nil {
+			t.Errorf("%s: no error", tt.response)
+			continue
+		}
+		e.Description = ""
+		if *e != tt.want {
+			t.Errorf("%s: classify() = %+v, want %+v", tt.response, *e, tt.want)
+		}
+	}
+}
+
+func TestSendForbiddenDeactivates(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	sn.Handle(textMessage(testChat, "/start"))
+	const desc = "Forbidden: bot can't initiate conversation with a user"
+	api.reply("sendMessage", `{"ok":false,"error_code":403,"description":"`+desc+`"}`)
+	err := sn.Send(testChat, "text")
+	if e := classify(err); e == nil || !e.Inactive() {
+		t.Fatalf("Send() = %v, want an inactive chat error", err)
+	}
+	cc, err := s.ListChats(false, -1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc) != 1 || cc[0].Active || cc[0].DeactivationReason != desc {
+		t.Errorf("chats = %+v, want the chat deactivated with %q", cc, desc)
+	}
+}
+
+func TestBadRequestAlertedOnce(t *testing.T) {
+	const admin = 200
+	sn, api, _ := newTestBot(t, &Config{SendOnly: true, Admins: []int64{admin}})
+	for _, chatID := range []int64{testChat, testChat + 1} {
+		api.reply("sendMessage", `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`)
+		err := sn.Send(chatID, "<b>")
+		if e := classify(err); e == nil || e.Kind != ErrBadRequest {
+			t.Fatalf("Send() = %v, want a bad request", err)
+		}
+	}
+	var alerts int
+	for _, r := range api.sent("sendMessage") {
+		if r.Params.Get("chat_id") == "200" {
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("admin is alerted %d times, want 1", alerts)
+	}
+}
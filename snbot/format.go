@@ -0,0 +1,128 @@
+package snbot
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// defaultTemplates render coupons in Telegram HTML, the codes are in
+// <code> to be copied with a tap. A templates file may redefine any of them.
+const defaultTemplates = `
+{{- define "coupon" -}}
+{{if .Description}}<b>{{.Description}}</b>
+{{end -}}
+{{t .Lang "label.code"}}: <code>{{.Code}}</code>
+{{t .Lang "label.expires"}}: {{.Expire}}
+{{- if .Link}}
+<a href="{{.Link}}">{{t .Lang "label.link"}}</a>{{end}}
+{{- end}}
+
+{{- define "coupon_short" -}}
+<code>{{.Code}}</code> — {{if .Link}}<a href="{{.Link}}">{{or .Description .Link}}</a>{{else}}{{.Description}}{{end}} ({{t .Lang "label.until"}} {{.Expire}})
+{{- end}}
+`
+
+// couponView is the coupon passed to the templates, the fields are escaped.
+type couponView struct {
+	Lang        locale.Lang
+	Code        string
+	Link        string
+	Description string
+	Expire      string
+}
+
+func newCouponView(l locale.Lang, rec collector.Record) couponView {
+	return couponView{
+		Lang:        l,
+		Code:        html.EscapeString(rec.Code),
+		Link:        html.EscapeString(rec.Link),
+		Description: html.EscapeString(rec.Description),
+		Expire:      time.Unix(rec.Date, 0).Format("02.01.2006"),
+	}
+}
+
+// formatter renders coupons with text/template in the HTML parse mode.
+type formatter struct {
+	tmpl *template.Template
+	// preview enables link previews in coupon messages.
+	preview bool
+}
+
+// newFormatter parses the default templates and the templates file, if any,
+// and checks they render for every language.
+func newFormatter(path string, preview bool) (*formatter, error) {
+	tmpl := template.New("").Funcs(template.FuncMap{
+		"t": func(l locale.Lang, key string, args ...interface{}) string {
+			return html.EscapeString(locale.T(l, key, args...))
+		},
+	})
+	tmpl, err := tmpl.Parse(defaultTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("failed parse default templates: %v", err)
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed read templates: %v", err)
+		}
+		tmpl, err = tmpl.Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed parse templates %s: %v", path, err)
+		}
+	}
+	f := &formatter{tmpl: tmpl, preview: preview}
+	sample := collector.Record{Code: "CODE", Link: "https://example.com", Description: "description", Date: time.Now().Unix()}
+	for _, l := range locale.Langs {
+		for _, name := range []string{"coupon", "coupon_short"} {
+			_, err := f.render(name, l, sample)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *formatter) render(name string, l locale.Lang, rec collector.Record) (string, error) {
+	var b strings.Builder
+	err := f.tmpl.ExecuteTemplate(&b, name, newCouponView(l, rec))
+	if err != nil {
+		return "", fmt.Errorf("failed render %s template: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+// coupon returns the full description of the coupon, the escaped code and
+// link are returned if the template fails.
+func (f *formatter) coupon(l locale.Lang, rec collector.Record) string {
+	return f.must("coupon", l, rec)
+}
+
+// couponShort returns the single line description of the coupon.
+func (f *formatter) couponShort(l locale.Lang, rec collector.Record) string {
+	return f.must("coupon_short", l, rec)
+}
+
+func (f *formatter) must(name string, l locale.Lang, rec collector.Record) string {
+	text, err := f.render(name, l, rec)
+	if err != nil {
+		return fmt.Sprintf("<code>%s</code> %s", html.EscapeString(rec.Code), html.EscapeString(rec.Link))
+	}
+	return text
+}
+
+// message prepares the HTML message with the formatter settings.
+func (f *formatter) message(m tgbotapi.MessageConfig) tgbotapi.MessageConfig {
+	m.ParseMode = tgbotapi.ModeHTML
+	m.DisableWebPagePreview = !f.preview
+	return m
+}
@@ -0,0 +1,66 @@
+package snbot
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestFormatGolden(t *testing.T) {
+	date := time.Date(2030, 1, 2, 12, 0, 0, 0, time.Local).Unix()
+	escaped := collector.Record{
+		Code:        `A<B>&"C"`,
+		Description: `<b>50%</b> off & "free" delivery`,
+		Link:        "https://example.com/?a=1&b=<2>",
+		Date:        date,
+	}
+	noLink := collector.Record{Code: "CODE", Description: "description", Date: date}
+	tests := []struct {
+		name      string
+		templates string
+		short     bool
+		lang      locale.Lang
+		rec       collector.Record
+	}{
+		{name: "escaped", lang: locale.English, rec: escaped},
+		{name: "escaped_ru", lang: locale.Russian, rec: escaped},
+		{name: "no_link", lang: locale.English, rec: noLink},
+		{name: "short", short: true, lang: locale.English, rec: escaped},
+		{name: "short_no_link", short: true, lang: locale.English, rec: noLink},
+		{name: "templates", templates: "testdata/templates.tmpl", lang: locale.English, rec: escaped},
+		{name: "templates_short", templates: "testdata/templates.tmpl", short: true, lang: locale.English, rec: noLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFormatter(tt.templates, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := f.coupon(tt.lang, tt.rec)
+			if tt.short {
+				got = f.couponShort(tt.lang, tt.rec)
+			}
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				err := ioutil.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
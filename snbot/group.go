@@ -0,0 +1,89 @@
+package snbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wenkaler/xfreehack/locale"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// addressed reports whether the command is meant for this bot,
+// in groups commands may be suffixed with a bot name: /print@xfree_bot.
+func (s *SNBot) addressed(message *tgbotapi.Message) bool {
+	cmd := message.CommandWithAt()
+	i := strings.Index(cmd, "@")
+	if i == -1 {
+		return true
+	}
+	return strings.EqualFold(cmd[i+1:], s.bot.Self().UserName)
+}
+
+// isGroup reports whether the message comes from a group or a supergroup.
+func isGroup(message *tgbotapi.Message) bool {
+	return message.Chat.IsGroup() || message.Chat.IsSuperGroup()
+}
+
+// isChatAdmin reports whether the author of the message administrates the chat.
+func (s *SNBot) isChatAdmin(message *tgbotapi.Message) (bool, error) {
+	if !isGroup(message) {
+		return true, nil
+	}
+	if message.From == nil {
+		return false, nil
+	}
+	m, err := s.bot.GetChatMember(tgbotapi.ChatConfigWithUser{
+		ChatID: message.Chat.ID,
+		UserID: message.From.ID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed get chat member: %v", err)
+	}
+	return m.IsCreator() || m.IsAdministrator(), nil
+}
+
+// service handles service messages about the chat membership and migration,
+// reports whether the message was a service one.
+func (s *SNBot) service(message *tgbotapi.Message) (bool, error) {
+	switch {
+	case message.MigrateToChatID != 0:
+		return true, s.migrate(message.Chat.ID, message.MigrateToChatID)
+	case message.MigrateFromChatID != 0:
+		return true, s.migrate(message.MigrateFromChatID, message.Chat.ID)
+	case message.LeftChatMember != nil:
+		if message.LeftChatMember.ID != s.bot.Self().ID {
+			return true, nil
+		}
+		level.Info(s.cfg.Logger).Log("msg", "bot removed from chat", "chatID", message.Chat.ID)
+		err := s.cfg.Storage.DeactivateChat(message.Chat.ID, "removed from chat")
+		if err != nil {
+			return true, fmt.Errorf("failed deactivate chat: %v", err)
+		}
+		return true, nil
+	case message.NewChatMembers != nil:
+		for _, u := range *message.NewChatMembers {
+			if u.ID != s.bot.Self().ID {
+				continue
+			}
+			level.Info(s.cfg.Logger).Log("msg", "bot added to chat", "chatID", message.Chat.ID)
+			err := s.cfg.Storage.NewChat(message.Chat)
+			if err != nil {
+				return true, fmt.Errorf("failed create new chat: %v", err)
+			}
+			return true, s.Send(message.Chat.ID, locale.T(s.lang(message.Chat.ID, message.From), "info"))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *SNBot) migrate(from, to int64) error {
+	level.Info(s.cfg.Logger).Log("msg", "chat migrated", "from", from, "to", to)
+	err := s.cfg.Storage.MigrateChat(from, to)
+	if err != nil {
+		return fmt.Errorf("failed migrate chat: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package snbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+)
+
+// statusText returns the name of the coupon status.
+func statusText(l locale.Lang, st model.CouponStatus) string {
+	return locale.T(l, fmt.Sprintf("status.%d", st))
+}
+
+// SendHistory sends the last coupons received by the chat.
+func (s *SNBot) SendHistory(chatID int64, lang locale.Lang, count int64) error {
+	records, err := s.cfg.Storage.GetHistory(chatID, count)
+	if err != nil {
+		return fmt.Errorf("failed get history: %v", err)
+	}
+	if len(records) == 0 {
+		return s.Send(chatID, locale.T(lang, "history.empty"))
+	}
+	var b strings.Builder
+	now := time.Now()
+	for i, rec := range records {
+		expire := time.Unix(rec.Date, 0)
+		b.WriteString(locale.T(lang, "history.item", i+1, rec.Code, statusText(lang, rec.Status), expire.Format("02.01.2006")))
+		if expire.Before(now) {
+			b.WriteString(locale.T(lang, "history.expired"))
+		}
+		b.WriteString("\n")
+	}
+	return s.Send(chatID, b.String())
+}
+
+// SendSaved sends the paginated list of saved coupons.
+func (s *SNBot) SendSaved(chatID int64, lang locale.Lang) error {
+	records, err := s.cfg.Storage.GetSaved(chatID)
+	if err != nil {
+		return fmt.Errorf("failed get saved coupons: %v", err)
+	}
+	if len(records) == 0 {
+		return s.Send(chatID, locale.T(lang, "saved.empty"))
+	}
+	return s.sendPage(chatID, lang, records, false)
+}
@@ -0,0 +1,54 @@
+package snbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wenkaler/xfreehack/locale"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const inlineLimit = 20
+
+// inline answers the inline query with matching active coupons.
+func (s *SNBot) inline(q *tgbotapi.InlineQuery) error {
+	offset, _ := strconv.Atoi(q.Offset)
+	if offset == 0 && strings.TrimSpace(q.Query) != "" {
+		err := s.cfg.Storage.LogSearch(int64(q.From.ID), strings.TrimSpace(q.Query))
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed log search", "err", err)
+		}
+	}
+	records, err := s.cfg.Storage.SearchCoupons(q.Query, inlineLimit, offset)
+	if err != nil {
+		return fmt.Errorf("failed search coupons: %v", err)
+	}
+	lang := s.lang(int64(q.From.ID), q.From)
+	results := make([]interface{}, 0, len(records))
+	for _, rec := range records {
+		expire := time.Unix(rec.Date, 0).Format("02.01.2006")
+		a := tgbotapi.NewInlineQueryResultArticle(rec.ID, locale.T(lang, "coupon.title", rec.Code, expire), "")
+		a.InputMessageContent = tgbotapi.InputTextMessageContent{
+			Text:                  s.format.coupon(lang, rec),
+			ParseMode:             tgbotapi.ModeHTML,
+			DisableWebPagePreview: !s.format.preview,
+		}
+		a.Description = rec.Description
+		a.URL = rec.Link
+		results = append(results, a)
+	}
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: q.ID,
+		Results:       results,
+		CacheTime:     60,
+	}
+	if len(records) == inlineLimit {
+		answer.NextOffset = strconv.Itoa(offset + inlineLimit)
+	}
+	_, err = s.bot.AnswerInlineQuery(answer)
+	return err
+}
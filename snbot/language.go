@@ -0,0 +1,57 @@
+package snbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wenkaler/xfreehack/locale"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// lang returns the language of the chat: the one chosen with /language,
+// or the Telegram language of the user when it was not chosen.
+func (s *SNBot) lang(chatID int64, from *tgbotapi.User) locale.Lang {
+	code, err := s.cfg.Storage.GetLanguage(chatID)
+	if err != nil {
+		level.Error(s.cfg.Logger).Log("msg", "failed get language", "chatID", chatID, "err", err)
+	}
+	if l, ok := locale.Parse(code); ok {
+		return l
+	}
+	if from != nil {
+		return locale.Detect(from.LanguageCode)
+	}
+	return locale.Default
+}
+
+// language sets the language of the command context.
+func (s *SNBot) language(next HandlerFunc) HandlerFunc {
+	return func(c *Context) error {
+		c.Lang = s.lang(c.ChatID(), c.Message.From)
+		return next(c)
+	}
+}
+
+// setLanguage handles the /language command: ru, en or auto.
+func (s *SNBot) setLanguage(c *Context) error {
+	arg := strings.TrimSpace(c.Message.CommandArguments())
+	if arg == "" {
+		return s.Send(c.ChatID(), locale.T(c.Lang, "usage.language", c.Lang.Name()))
+	}
+	var code string
+	if arg != "auto" {
+		l, ok := locale.Parse(arg)
+		if !ok {
+			return s.Send(c.ChatID(), locale.T(c.Lang, "usage.language", c.Lang.Name()))
+		}
+		code = string(l)
+	}
+	err := s.cfg.Storage.SetLanguage(c.ChatID(), code)
+	if err != nil {
+		return fmt.Errorf("failed set language: %v", err)
+	}
+	l := s.lang(c.ChatID(), c.Message.From)
+	return s.Send(c.ChatID(), locale.T(l, "language.set", l.Name()))
+}
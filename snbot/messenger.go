@@ -0,0 +1,132 @@
+package snbot
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Messenger is the transport to the Telegram Bot API used by the bot.
+type Messenger interface {
+	// Self returns the bot account.
+	Self() tgbotapi.User
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	AnswerInlineQuery(config tgbotapi.InlineConfig) (tgbotapi.APIResponse, error)
+	GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+	// Request calls an API method the client has no helper for.
+	Request(method string, params url.Values) (tgbotapi.APIResponse, error)
+	// Upload calls an API method with a file.
+	Upload(method string, params map[string]string, field string, file interface{}) (tgbotapi.APIResponse, error)
+	// Updates starts long polling.
+	Updates(timeout int) (tgbotapi.UpdatesChannel, error)
+	// StopUpdates stops long polling.
+	StopUpdates()
+}
+
+// ResponseError is a request refused by the Bot API. Unlike tgbotapi.Error
+// it keeps the error_code of the response.
+type ResponseError struct {
+	Code        int
+	Description string
+	tgbotapi.ResponseParameters
+}
+
+func (e *ResponseError) Error() string {
+	return e.Description
+}
+
+// withCode adds the error_code of the response to the error of the client.
+func withCode(resp tgbotapi.APIResponse, err error) error {
+	e, ok := err.(tgbotapi.Error)
+	if !ok {
+		return err
+	}
+	return &ResponseError{Code: resp.ErrorCode, Description: e.Message, ResponseParameters: e.ResponseParameters}
+}
+
+// telegram is the Messenger talking to the real Bot API.
+type telegram struct {
+	*tgbotapi.BotAPI
+}
+
+// NewTelegram authorizes the bot with the token.
+func NewTelegram(token string) (Messenger, error) {
+	bot, err := tgbotapi.NewBotAPI(token)
+	if err != nil {
+		return nil, err
+	}
+	return &telegram{BotAPI: bot}, nil
+}
+
+func (t *telegram) Self() tgbotapi.User {
+	return t.BotAPI.Self
+}
+
+// Send sends the messages itself to return *ResponseError on failures,
+// other requests are sent by the client.
+func (t *telegram) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m, ok := c.(tgbotapi.MessageConfig)
+	if !ok {
+		return t.BotAPI.Send(c)
+	}
+	v, err := messageValues(m)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	resp, err := t.Request("sendMessage", v)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var msg tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &msg)
+	return msg, err
+}
+
+// messageValues returns the parameters of sendMessage as the client does.
+func messageValues(m tgbotapi.MessageConfig) (url.Values, error) {
+	v := url.Values{}
+	if m.ChannelUsername != "" {
+		v.Add("chat_id", m.ChannelUsername)
+	} else {
+		v.Add("chat_id", strconv.FormatInt(m.ChatID, 10))
+	}
+	if m.ReplyToMessageID != 0 {
+		v.Add("reply_to_message_id", strconv.Itoa(m.ReplyToMessageID))
+	}
+	if m.ReplyMarkup != nil {
+		data, err := json.Marshal(m.ReplyMarkup)
+		if err != nil {
+			return v, err
+		}
+		v.Add("reply_markup", string(data))
+	}
+	v.Add("disable_notification", strconv.FormatBool(m.DisableNotification))
+	v.Add("text", m.Text)
+	v.Add("disable_web_page_preview", strconv.FormatBool(m.DisableWebPagePreview))
+	if m.ParseMode != "" {
+		v.Add("parse_mode", m.ParseMode)
+	}
+	return v, nil
+}
+
+func (t *telegram) Request(method string, params url.Values) (tgbotapi.APIResponse, error) {
+	resp, err := t.MakeRequest(method, params)
+	return resp, withCode(resp, err)
+}
+
+func (t *telegram) Upload(method string, params map[string]string, field string, file interface{}) (tgbotapi.APIResponse, error) {
+	return t.UploadFile(method, params, field, file)
+}
+
+func (t *telegram) Updates(timeout int) (tgbotapi.UpdatesChannel, error) {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = timeout
+	return t.GetUpdatesChan(u)
+}
+
+func (t *telegram) StopUpdates() {
+	t.StopReceivingUpdates()
+}
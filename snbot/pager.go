@@ -0,0 +1,392 @@
+package snbot
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wenkaler/xfreehack/collector"
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const pageSize = 5
+
+// footerReserve is the room left in a page message for its footer.
+const footerReserve = 256
+
+const (
+	// pageTTL is how long a paginated list stays navigable.
+	pageTTL = 24 * time.Hour
+	// maxPageLists is the number of lists kept, the least recently
+	// used ones are dropped above it.
+	maxPageLists = 10000
+)
+
+const (
+	cbPage = "page"
+	cbMore = "more"
+	cbCode = "code"
+	cbVote = "vote"
+	cbStat = "state"
+	cbNoop = "noop"
+)
+
+// pageList is the list of coupons shown in a single paginated message.
+type pageList struct {
+	messageID int
+	page      int
+	records   []collector.Record
+	lang      locale.Lang
+	// more allows to load the next not used coupons.
+	more bool
+	// items are the rendered records, bounds are the indexes of the
+	// first item of every page.
+	items  []string
+	bounds []int
+	// used is when the list was sent or navigated the last time.
+	used time.Time
+}
+
+func (l *pageList) pages() int {
+	if len(l.bounds) == 0 {
+		return 1
+	}
+	return len(l.bounds)
+}
+
+// span returns the range of the records on the current page.
+func (l *pageList) span() (int, int) {
+	if len(l.bounds) == 0 {
+		return 0, 0
+	}
+	to := len(l.records)
+	if l.page+1 < len(l.bounds) {
+		to = l.bounds[l.page+1]
+	}
+	return l.bounds[l.page], to
+}
+
+// pager keeps the last paginated list of every chat, older messages
+// are not navigable anymore. Lists expire after ttl and at most max
+// of them are kept.
+type pager struct {
+	mu    sync.Mutex
+	lists map[int64]*pageList
+	ttl   time.Duration
+	max   int
+}
+
+func newPager() *pager {
+	return &pager{lists: make(map[int64]*pageList), ttl: pageTTL, max: maxPageLists}
+}
+
+func (p *pager) set(chatID int64, l *pageList) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	l.used = now
+	p.lists[chatID] = l
+	if len(p.lists) <= p.max {
+		return
+	}
+	var (
+		oldest int64
+		found  bool
+	)
+	for id, l := range p.lists {
+		if now.Sub(l.used) >= p.ttl {
+			delete(p.lists, id)
+			continue
+		}
+		if !found || l.used.Before(p.lists[oldest].used) {
+			oldest, found = id, true
+		}
+	}
+	if len(p.lists) > p.max {
+		delete(p.lists, oldest)
+	}
+}
+
+// get returns the list of the message, false if it is unknown or expired.
+func (p *pager) get(chatID int64, messageID int) (*pageList, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.lists[chatID]
+	if !ok || l.messageID != messageID {
+		return nil, false
+	}
+	now := time.Now()
+	if now.Sub(l.used) >= p.ttl {
+		delete(p.lists, chatID)
+		return nil, false
+	}
+	l.used = now
+	return l, true
+}
+
+// paginate renders the records and splits them to pages of at most
+// pageSize coupons fitting in a single message.
+func (s *SNBot) paginate(l *pageList) {
+	l.items = l.items[:0]
+	l.bounds = l.bounds[:0]
+	var size, count int
+	for i, rec := range l.records {
+		item := fmt.Sprintf("%v. %s\n\n", i+1, s.fitCoupon(l.lang, rec))
+		n := textLen(item)
+		if i == 0 || count == pageSize || size+n > messageLimit-footerReserve {
+			l.bounds = append(l.bounds, i)
+			size, count = 0, 0
+		}
+		size += n
+		count++
+		l.items = append(l.items, item)
+	}
+}
+
+// fitCoupon returns the coupon text cut to fit in a page message.
+func (s *SNBot) fitCoupon(lang locale.Lang, rec collector.Record) string {
+	text := s.format.coupon(lang, rec)
+	if limit := messageLimit - footerReserve - 16; textLen(text) > limit {
+		text = splitMessage(text, limit, true)[0] + "…"
+	}
+	return text
+}
+
+func (s *SNBot) renderPage(l *pageList, remain uint64) string {
+	var b strings.Builder
+	from, to := l.span()
+	for i := from; i < to; i++ {
+		b.WriteString(l.items[i])
+	}
+	b.WriteString(html.EscapeString(locale.T(l.lang, "page", l.page+1, l.pages())))
+	if remain != 0 {
+		b.WriteString("\n" + html.EscapeString(locale.N(l.lang, "coupons.remain", int(remain), remain)))
+	}
+	return b.String()
+}
+
+func pageKeyboard(l *pageList, remain uint64) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	from, to := l.span()
+	for i := from; i < to; i++ {
+		rows = append(rows, couponRows(i+1, l.records[i])...)
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if l.page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀", callbackData(cbPage, strconv.Itoa(l.page-1))))
+	}
+	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", l.page+1, l.pages()), cbNoop))
+	if l.page < l.pages()-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶", callbackData(cbPage, strconv.Itoa(l.page+1))))
+	} else if remain != 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(locale.T(l.lang, "page.more"), cbMore))
+	}
+	rows = append(rows, nav)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// couponRows returns the buttons attached to a single coupon.
+func couponRows(n int, rec collector.Record) [][]tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	if rec.Link != "" {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonURL(fmt.Sprintf("🔗 %d", n), rec.Link))
+	}
+	row = append(row,
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📋 %d", n), callbackData(cbCode, rec.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("✅", callbackData(cbVote, rec.ID, "1")),
+		tgbotapi.NewInlineKeyboardButtonData("❌", callbackData(cbVote, rec.ID, "-1")),
+	)
+	return [][]tgbotapi.InlineKeyboardButton{row, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("💾", callbackData(cbStat, rec.ID, strconv.Itoa(int(model.StatusSaved)))),
+		tgbotapi.NewInlineKeyboardButtonData("✔️", callbackData(cbStat, rec.ID, strconv.Itoa(int(model.StatusUsed)))),
+		tgbotapi.NewInlineKeyboardButtonData("🗑", callbackData(cbStat, rec.ID, strconv.Itoa(int(model.StatusDismissed)))),
+	)}
+}
+
+func callbackData(action string, args ...string) string {
+	return strings.Join(append([]string{action}, args...), ":")
+}
+
+func parseCallbackData(data string) (string, []string) {
+	ss := strings.Split(data, ":")
+	return ss[0], ss[1:]
+}
+
+// sendPage sends a new paginated list of coupons,
+// with more the records are expected to be not marked as read yet.
+func (s *SNBot) sendPage(chatID int64, lang locale.Lang, records []collector.Record, more bool) error {
+	l := &pageList{records: records, lang: lang, more: more}
+	s.paginate(l)
+	remain, err := s.remain(chatID, l, len(records))
+	if err != nil {
+		return err
+	}
+	m := s.format.message(tgbotapi.NewMessage(chatID, s.renderPage(l, remain)))
+	m.ReplyMarkup = pageKeyboard(l, remain)
+	msg, err := s.send(m)
+	if err != nil {
+		return err
+	}
+	l.messageID = msg.MessageID
+	s.pager.set(chatID, l)
+	return nil
+}
+
+// editPage redraws an already sent paginated list, unread is the number
+// of its records not marked as read yet.
+func (s *SNBot) editPage(chatID int64, l *pageList, unread int) error {
+	remain, err := s.remain(chatID, l, unread)
+	if err != nil {
+		return err
+	}
+	m := tgbotapi.NewEditMessageText(chatID, l.messageID, s.renderPage(l, remain))
+	m.ParseMode = tgbotapi.ModeHTML
+	m.DisableWebPagePreview = !s.format.preview
+	kb := pageKeyboard(l, remain)
+	m.ReplyMarkup = &kb
+	_, err = s.bot.Send(m)
+	return err
+}
+
+// remain returns the number of coupons left in the database,
+// the unread records of the list are not counted.
+func (s *SNBot) remain(chatID int64, l *pageList, unread int) (uint64, error) {
+	if !l.more {
+		return 0, nil
+	}
+	remain, err := s.cfg.Storage.CountNotUseCoupon(chatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed get count coupons: %v", err)
+	}
+	if n := uint64(unread); remain > n {
+		return remain - n, nil
+	}
+	return 0, nil
+}
+
+// couponKeyboard returns the buttons for a plain list of coupons,
+// numbered after first.
+func couponKeyboard(records []collector.Record, first int) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, rec := range records {
+		rows = append(rows, couponRows(first+i+1, rec)...)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (s *SNBot) callback(q *tgbotapi.CallbackQuery) error {
+	var answer tgbotapi.CallbackConfig
+	defer func() {
+		s.bot.AnswerCallbackQuery(answer)
+	}()
+	answer = tgbotapi.NewCallback(q.ID, "")
+	if q.Message == nil {
+		return nil
+	}
+	chatID := q.Message.Chat.ID
+	lang := s.lang(chatID, q.From)
+	action, args := parseCallbackData(q.Data)
+	switch action {
+	case cbPage, cbMore:
+		l, ok := s.pager.get(chatID, q.Message.MessageID)
+		if !ok {
+			answer.Text = locale.T(lang, "page.expired")
+			return nil
+		}
+		if action == cbMore {
+			if !l.more {
+				return nil
+			}
+			records, err := s.cfg.Storage.GetNotUseCouponCount(chatID, pageSize)
+			if err != nil {
+				return fmt.Errorf("failed get coupons: %v", err)
+			}
+			if len(records) == 0 {
+				answer.Text = locale.T(lang, "coupons.empty")
+				return nil
+			}
+			// the coupons are read only once they are shown.
+			prev, page := l.records, l.page
+			l.records = append(l.records[:len(l.records):len(l.records)], records...)
+			s.paginate(l)
+			l.page = l.pages() - 1
+			err = s.editPage(chatID, l, len(records))
+			if err != nil {
+				l.records, l.page = prev, page
+				s.paginate(l)
+				return err
+			}
+			err = s.cfg.Storage.MarkAsRead(chatID, records)
+			if err != nil {
+				return fmt.Errorf("failed marked as read: %v", err)
+			}
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		p, err := strconv.Atoi(args[0])
+		if err != nil || p < 0 || p >= l.pages() {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		l.page = p
+		return s.editPage(chatID, l, 0)
+	case cbCode:
+		if len(args) != 1 {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		rec, err := s.cfg.Storage.GetRecord(args[0])
+		if err != nil {
+			return fmt.Errorf("failed get record: %v", err)
+		}
+		answer = tgbotapi.NewCallbackWithAlert(q.ID, rec.Code)
+		err = s.cfg.Storage.LogEvent(chatID, rec.ID, model.EventCode)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed log event", "err", err)
+		}
+	case cbVote:
+		if len(args) != 2 || (args[1] != "1" && args[1] != "-1") {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		vote, _ := strconv.Atoi(args[1])
+		err := s.vote(chatID, args[0], vote)
+		if err != nil {
+			return err
+		}
+		answer.Text = locale.T(lang, "vote.thanks")
+	case cbAnnounce:
+		text, err := s.confirmAnnounce(q, lang, args)
+		if err != nil {
+			return err
+		}
+		answer.Text = text
+	case cbStat:
+		if len(args) != 2 {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		st, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad callback data: %q", q.Data)
+		}
+		err = s.cfg.Storage.SetCouponStatus(chatID, args[0], model.CouponStatus(st))
+		switch {
+		case err == model.ErrBadTransition:
+			answer.Text = locale.T(lang, "status.locked")
+		case err == sql.ErrNoRows:
+			answer.Text = locale.T(lang, "status.notfound")
+		case err != nil:
+			return fmt.Errorf("failed set coupon status: %v", err)
+		default:
+			answer.Text = locale.T(lang, "status.set", statusText(lang, model.CouponStatus(st)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package snbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/locale"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestPagerExpires(t *testing.T) {
+	p := newPager()
+	p.set(1, &pageList{messageID: 10})
+	if _, ok := p.get(1, 10); !ok {
+		t.Fatal("list is not found")
+	}
+	if _, ok := p.get(1, 11); ok {
+		t.Error("list of another message is found")
+	}
+	p.lists[1].used = time.Now().Add(-pageTTL)
+	if _, ok := p.get(1, 10); ok {
+		t.Error("expired list is found")
+	}
+	if len(p.lists) != 0 {
+		t.Errorf("expired list is kept")
+	}
+}
+
+func TestPagerBounded(t *testing.T) {
+	p := newPager()
+	p.max = 2
+	for id := int64(1); id <= 3; id++ {
+		p.set(id, &pageList{messageID: int(id)})
+		// the lists are used in order.
+		p.lists[id].used = time.Now().Add(time.Duration(id-4) * time.Minute)
+	}
+	if len(p.lists) != 2 {
+		t.Fatalf("%d lists are kept, want 2", len(p.lists))
+	}
+	if _, ok := p.get(1, 1); ok {
+		t.Error("the least recently used list is kept")
+	}
+}
+
+func TestPageCallbackExpired(t *testing.T) {
+	sn, api, _ := newTestBot(t, &Config{SendOnly: true})
+	sn.Handle(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "q1",
+		From: &tgbotapi.User{ID: testChat, LanguageCode: "en"},
+		Message: &tgbotapi.Message{
+			MessageID: 5,
+			Chat:      &tgbotapi.Chat{ID: testChat, Type: "private"},
+		},
+		Data: callbackData(cbPage, "1"),
+	}})
+	rr := api.sent("answerCallbackQuery")
+	if len(rr) != 1 || rr[0].Params.Get("text") != locale.T(locale.English, "page.expired") {
+		t.Errorf("answerCallbackQuery requests = %v, want the expired page answer", rr)
+	}
+	if len(api.sent("editMessageText")) != 0 {
+		t.Error("unknown page is edited")
+	}
+}
+
+func TestMoreEditFailed(t *testing.T) {
+	sn, api, s := newTestBot(t, &Config{SendOnly: true})
+	collectCoupons(t, s, 7)
+	sn.Handle(textMessage(testChat, "/print"))
+	api.reply("editMessageText", `{"ok":false,"error_code":400,"description":"Bad Request: message to edit not found"}`)
+	more := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "q1",
+		From: &tgbotapi.User{ID: testChat, LanguageCode: "en"},
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: testChat, Type: "private"},
+		},
+		Data: cbMore,
+	}}
+	sn.Handle(more)
+	// the coupons are not shown, so they stay unread.
+	if n := countNotUsed(t, s); n != 2 {
+		t.Fatalf("not used coupons = %d, want 2", n)
+	}
+	sn.Handle(more)
+	if n := countNotUsed(t, s); n != 0 {
+		t.Errorf("not used coupons = %d, want 0", n)
+	}
+	rr := api.sent("editMessageText")
+	if len(rr) != 2 || rr[0].Params.Get("text") != rr[1].Params.Get("text") {
+		t.Errorf("editMessageText requests = %v, want the same page twice", rr)
+	}
+}
@@ -0,0 +1,280 @@
+package snbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wenkaler/xfreehack/locale"
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Context is a single command invocation.
+type Context struct {
+	Message *tgbotapi.Message
+	Command *Command
+	// Args is the value returned by the command argument parser.
+	Args interface{}
+	// Lang is the language of the chat.
+	Lang locale.Lang
+}
+
+// ChatID returns the chat the command was sent to.
+func (c *Context) ChatID() int64 {
+	return c.Message.Chat.ID
+}
+
+// HandlerFunc handles a command.
+type HandlerFunc func(c *Context) error
+
+// Middleware wraps the command handler.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// ArgParser converts the raw command arguments to a value for the handler.
+type ArgParser func(raw string) (interface{}, error)
+
+// errUsage is returned by argument parsers on malformed arguments.
+var errUsage = errors.New("bad command arguments")
+
+// Command is a bot command, Description and Usage are message catalog keys.
+type Command struct {
+	Name        string
+	Description string
+	// Usage is sent when the arguments can not be parsed.
+	Usage   string
+	Handler HandlerFunc
+	Args    ArgParser
+	// Role is required to run the command, admin commands are not
+	// announced to Telegram and are hidden from /help of other users.
+	Role model.Role
+	// Settings commands may only be used by chat administrators in groups.
+	Settings bool
+}
+
+// router dispatches commands to their handlers through the middleware chain.
+type router struct {
+	commands   map[string]*Command
+	middleware []Middleware
+	// unknown handles commands which are not registered.
+	unknown HandlerFunc
+}
+
+func newRouter() *router {
+	return &router{commands: make(map[string]*Command)}
+}
+
+// Register adds the command.
+func (r *router) Register(c Command) {
+	r.commands[c.Name] = &c
+}
+
+// Use appends middleware, the first one is the outermost.
+func (r *router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// list returns commands sorted by name available to the role.
+func (r *router) list(role model.Role) []*Command {
+	var cc []*Command
+	for _, c := range r.commands {
+		if c.Role <= role {
+			cc = append(cc, c)
+		}
+	}
+	sort.Slice(cc, func(i, j int) bool { return cc[i].Name < cc[j].Name })
+	return cc
+}
+
+func (r *router) dispatch(message *tgbotapi.Message) error {
+	c, ok := r.commands[message.Command()]
+	h := r.unknown
+	if ok {
+		h = c.run
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h(&Context{Message: message, Command: c})
+}
+
+// run parses the arguments and calls the handler.
+func (c *Command) run(ctx *Context) error {
+	if c.Args != nil {
+		v, err := c.Args(ctx.Message.CommandArguments())
+		if err != nil {
+			return errUsage
+		}
+		ctx.Args = v
+	}
+	return c.Handler(ctx)
+}
+
+// intArg parses an optional positive number not greater than max.
+func intArg(def, max int64) ArgParser {
+	return func(raw string) (interface{}, error) {
+		ss := strings.Fields(raw)
+		if len(ss) == 0 {
+			return def, nil
+		}
+		n, err := strconv.ParseInt(ss[0], 10, 64)
+		if err != nil || n <= 0 || n > max {
+			return nil, errUsage
+		}
+		return n, nil
+	}
+}
+
+// logging logs every command with its duration.
+func (s *SNBot) logging(next HandlerFunc) HandlerFunc {
+	return func(c *Context) error {
+		begin := time.Now()
+		err := next(c)
+		level.Debug(s.cfg.Logger).Log("msg", "command", "command", c.Message.Command(), "chatID", c.ChatID(), "time elapsed", time.Since(begin), "err", err)
+		return err
+	}
+}
+
+// recovery converts a panic in the handler to an error.
+func (s *SNBot) recovery(next HandlerFunc) HandlerFunc {
+	return func(c *Context) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				level.Error(s.cfg.Logger).Log("msg", "panic in command", "command", c.Message.Command(), "panic", r, "stack", string(debug.Stack()))
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return next(c)
+	}
+}
+
+// usage replies with the command usage on malformed arguments.
+func (s *SNBot) usage(next HandlerFunc) HandlerFunc {
+	return func(c *Context) error {
+		err := next(c)
+		if err == errUsage {
+			return s.Send(c.ChatID(), locale.T(c.Lang, c.Command.Usage))
+		}
+		return err
+	}
+}
+
+// auth checks chat administrators for settings commands and admin roles.
+func (s *SNBot) auth(next HandlerFunc) HandlerFunc {
+	return func(c *Context) error {
+		if c.Command == nil {
+			return next(c)
+		}
+		if c.Command.Settings {
+			admin, err := s.isChatAdmin(c.Message)
+			if err != nil {
+				return err
+			}
+			if !admin {
+				return s.Send(c.ChatID(), locale.T(c.Lang, "chat_admin_only"))
+			}
+		}
+		if c.Command.Role != model.RoleNone {
+			allowed, err := s.authorize(c.Message.From, c.ChatID(), c.Command.Role, c.Command.Name, c.Message.CommandArguments())
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return s.unknownCommand(c)
+			}
+		}
+		return next(c)
+	}
+}
+
+// userRate is the number of commands a user may send per minute.
+const userRate = 20
+
+// rateLimit rejects commands of users flooding the bot.
+func (s *SNBot) rateLimit(next HandlerFunc) HandlerFunc {
+	var (
+		mu      sync.Mutex
+		window  time.Time
+		counter = make(map[int]int)
+	)
+	return func(c *Context) error {
+		if c.Message.From == nil {
+			return next(c)
+		}
+		mu.Lock()
+		if now := time.Now(); now.Sub(window) > time.Minute {
+			window = now
+			counter = make(map[int]int)
+		}
+		counter[c.Message.From.ID]++
+		n := counter[c.Message.From.ID]
+		mu.Unlock()
+		switch {
+		case n == userRate+1:
+			level.Warn(s.cfg.Logger).Log("msg", "user rate limited", "user", c.Message.From.ID)
+			return s.Send(c.ChatID(), locale.T(c.Lang, "rate_limited"))
+		case n > userRate:
+			return nil
+		}
+		return next(c)
+	}
+}
+
+func (s *SNBot) unknownCommand(c *Context) error {
+	return s.Send(c.ChatID(), locale.T(c.Lang, "unknown_command"))
+}
+
+// help lists the commands available to the user.
+func (s *SNBot) help(c *Context) error {
+	role := model.RoleNone
+	if c.Message.From != nil {
+		r, err := s.cfg.Storage.GetRole(int64(c.Message.From.ID))
+		if err != nil {
+			return fmt.Errorf("failed get role: %v", err)
+		}
+		role = r
+	}
+	var b strings.Builder
+	for _, cmd := range s.router.list(role) {
+		fmt.Fprintf(&b, "/%s - %s\n", cmd.Name, locale.T(c.Lang, cmd.Description))
+	}
+	return s.Send(c.ChatID(), b.String())
+}
+
+// setMyCommands announces the public commands to Telegram in every language,
+// the default language is used for the users of other languages.
+func (s *SNBot) setMyCommands() error {
+	type botCommand struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	for _, l := range locale.Langs {
+		var cc []botCommand
+		for _, c := range s.router.list(model.RoleNone) {
+			cc = append(cc, botCommand{Command: c.Name, Description: locale.T(l, c.Description)})
+		}
+		data, err := json.Marshal(cc)
+		if err != nil {
+			return err
+		}
+		v := url.Values{}
+		v.Add("commands", string(data))
+		if l != locale.Default {
+			v.Add("language_code", string(l))
+		}
+		_, err = s.bot.Request("setMyCommands", v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package snbot
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// messageLimit is the maximum length of a message text.
+const messageLimit = 4096
+
+// textLen returns the length of the text as Telegram counts it, in UTF-16
+// code units. Tags are counted too, so the length is an upper bound.
+func textLen(s string) int {
+	var n int
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// openTag is an HTML tag not closed at a cut of the text.
+type openTag struct {
+	name string
+	// tag is the tag as written, it is repeated in the next chunk.
+	tag string
+}
+
+// splitMessage splits the text to chunks not longer than limit. The text is
+// cut at line breaks, spaces or anywhere if there are none, in the HTML mode
+// never inside a tag or an entity. Tags open at a cut are closed at the end
+// of the chunk and opened again in the next one.
+func splitMessage(text string, limit int, html bool) []string {
+	var chunks []string
+	for textLen(text) > limit {
+		cut, open := cutPoint(text, limit, html)
+		chunk := strings.TrimRight(text[:cut], " \n") + closeTags(open)
+		rest := strings.TrimLeft(text[cut:], " \n")
+		for i := len(open) - 1; i >= 0; i-- {
+			rest = open[i].tag + rest
+		}
+		chunks = append(chunks, chunk)
+		text = rest
+	}
+	if strings.TrimSpace(text) != "" || len(chunks) == 0 {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// cutPoint returns the byte offset to cut the text at and the tags open there.
+func cutPoint(text string, limit int, html bool) (int, []openTag) {
+	type cut struct {
+		at   int
+		open []openTag
+	}
+	var (
+		n, visible, tagStart int
+		inTag, inEntity      bool
+		stack                []openTag
+		line, space, any     cut
+		// first is the earliest valid cut, taken when the tags alone
+		// do not fit in the limit.
+		first cut
+	)
+	for i, r := range text {
+		// a chunk has to contain some text besides the reopened tags.
+		if !inTag && !inEntity && visible > 0 {
+			c := cut{at: i, open: append([]openTag(nil), stack...)}
+			if first.at == 0 {
+				first = c
+			}
+			if n+textLen(closeTags(stack)) > limit {
+				break
+			}
+			any = c
+			switch text[i-1] {
+			case '\n':
+				line = c
+			case ' ':
+				space = c
+			}
+		}
+		n += textLen(string(r))
+		if !html {
+			visible++
+			continue
+		}
+		if !inTag && r != '<' {
+			visible++
+		}
+		switch {
+		case r == '<' && !inTag:
+			inTag, tagStart = true, i
+		case r == '>' && inTag:
+			inTag = false
+			tag := text[tagStart : i+1]
+			if strings.HasPrefix(tag, "</") {
+				if len(stack) != 0 {
+					stack = stack[:len(stack)-1]
+				}
+				continue
+			}
+			// an empty tag such as <> is plain text.
+			fields := strings.Fields(tag[1 : len(tag)-1])
+			if len(fields) == 0 {
+				continue
+			}
+			name := strings.Trim(fields[0], "/")
+			stack = append(stack, openTag{name: name, tag: tag})
+		case r == '&' && !inTag:
+			inEntity = true
+		case r == ';' && inEntity:
+			inEntity = false
+		}
+	}
+	for _, c := range []cut{line, space, any, first} {
+		if c.at != 0 {
+			return c.at, c.open
+		}
+	}
+	return len(text), nil
+}
+
+// closeTags returns the closing tags for the open ones, innermost first.
+func closeTags(open []openTag) string {
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].name + ">")
+	}
+	return b.String()
+}
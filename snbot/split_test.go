@@ -0,0 +1,104 @@
+package snbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	link := `<a href="https://example.com/` + strings.Repeat("x", 40) + `">`
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		html  bool
+		want  []string
+	}{
+		{
+			name:  "short",
+			text:  "hello",
+			limit: 10,
+			want:  []string{"hello"},
+		},
+		{
+			name:  "line breaks first",
+			text:  "aaa bbb\nccc ddd",
+			limit: 10,
+			want:  []string{"aaa bbb", "ccc ddd"},
+		},
+		{
+			name:  "no spaces",
+			text:  "abcdefghijkl",
+			limit: 5,
+			want:  []string{"abcde", "fghij", "kl"},
+		},
+		{
+			name:  "plain text keeps tags",
+			text:  "<b>aaaa</b>",
+			limit: 5,
+			want:  []string{"<b>aa", "aa</b", ">"},
+		},
+		{
+			name:  "entity is not cut",
+			text:  "aaa &amp; bbb",
+			limit: 7,
+			html:  true,
+			want:  []string{"aaa", "&amp;", "bbb"},
+		},
+		{
+			name:  "tag is closed and reopened",
+			text:  "<b>aaa bbb ccc</b>",
+			limit: 15,
+			html:  true,
+			want:  []string{"<b>aaa bbb</b>", "<b>ccc</b>"},
+		},
+		{
+			name:  "nested tags",
+			text:  "<b><i>aaa bbb</i> ccc</b>",
+			limit: 18,
+			html:  true,
+			want:  []string{"<b><i>aaa</i></b>", "<b><i>bbb</i></b>", "<b>ccc</b>"},
+		},
+		{
+			name:  "long link attribute",
+			text:  link + "aaa bbb</a>",
+			limit: len(link) + 8,
+			html:  true,
+			want:  []string{link + "aaa</a>", link + "bbb</a>"},
+		},
+		{
+			name:  "cyrillic at the limit",
+			text:  "привет мир",
+			limit: 6,
+			want:  []string{"привет", "мир"},
+		},
+		{
+			name: "emoji counts twice",
+			// every emoji is two UTF-16 code units.
+			text:  "😀😀😀",
+			limit: 4,
+			want:  []string{"😀😀", "😀"},
+		},
+		{
+			name:  "empty tag is plain text",
+			text:  "a <> b xxxxxxxxxxxxxxxxxxxx",
+			limit: 10,
+			html:  true,
+			want:  []string{"a <> b", "xxxxxxxxxx", "xxxxxxxxxx"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit, tt.html)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitMessage(%q, %d, %t) = %q, want %q", tt.text, tt.limit, tt.html, got, tt.want)
+			}
+			for _, c := range got {
+				if textLen(c) > tt.limit {
+					t.Errorf("chunk %q is longer than %d", c, tt.limit)
+				}
+			}
+		})
+	}
+}
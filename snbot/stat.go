@@ -0,0 +1,81 @@
+package snbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wenkaler/xfreehack/locale"
+)
+
+const (
+	statDays     = 7
+	maxStatDays  = 90
+	chartWidth   = 12
+	chartSymbol  = "█"
+	percentScale = 100
+)
+
+// SendStat sends the analytics for the last days.
+func (s *SNBot) SendStat(chatID int64, lang locale.Lang, days int) error {
+	st, err := s.cfg.Storage.GetStats(days)
+	if err != nil {
+		return fmt.Errorf("failed get stats: %v", err)
+	}
+	var b strings.Builder
+	b.WriteString(locale.T(lang, "stat.active", st.ActiveChats))
+	b.WriteString(locale.N(lang, "stat.days", days, days))
+	var max int
+	for _, d := range st.Days {
+		if d.Delivered > max {
+			max = d.Delivered
+		}
+	}
+	for _, d := range st.Days {
+		fmt.Fprintf(&b, "%s +%d -%d %s %d\n", d.Day[5:], d.Joined, d.Left, bar(d.Delivered, max), d.Delivered)
+	}
+	if len(st.Sources) != 0 {
+		b.WriteString(locale.T(lang, "stat.sources"))
+		for _, c := range st.Sources {
+			key := c.Key
+			if key == "" {
+				key = locale.T(lang, "stat.unknown")
+			}
+			fmt.Fprintf(&b, "%s: %d\n", key, c.Count)
+		}
+	}
+	b.WriteString(locale.T(lang, "stat.engagement"))
+	b.WriteString(locale.T(lang, "stat.clicks", st.Clicks, percent(st.Clicks, st.Delivered)))
+	b.WriteString(locale.T(lang, "stat.votes", st.VotesUp, st.VotesDown, percent(st.VotesUp+st.VotesDown, st.Delivered)))
+	if len(st.TopQueries) != 0 {
+		b.WriteString(locale.T(lang, "stat.queries"))
+		for i, q := range st.TopQueries {
+			fmt.Fprintf(&b, "%d. %s — %d\n", i+1, q.Key, q.Count)
+		}
+	}
+	if len(st.Cohorts) != 0 {
+		b.WriteString(locale.T(lang, "stat.retention"))
+		for _, c := range st.Cohorts {
+			fmt.Fprintf(&b, "%s: %d / %d %s %s\n", c.Week, c.Joined, c.Active, bar(c.Active, c.Joined), percent(c.Active, c.Joined))
+		}
+	}
+	return s.Send(chatID, b.String())
+}
+
+// bar draws a text bar of the value relative to max.
+func bar(value, max int) string {
+	if max == 0 || value <= 0 {
+		return ""
+	}
+	n := value * chartWidth / max
+	if n == 0 {
+		n = 1
+	}
+	return strings.Repeat(chartSymbol, n)
+}
+
+func percent(value, total int) string {
+	if total == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%.1f%%", float64(value)*percentScale/float64(total))
+}
@@ -0,0 +1,166 @@
+package snbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wenkaler/xfreehack/storage"
+
+	"github.com/go-kit/kit/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// apiRequest is a call recorded by the fake Bot API.
+type apiRequest struct {
+	Method string
+	Params url.Values
+}
+
+// fakeAPI is a stand-in of the Bot API answering getMe, sendMessage and
+// editMessageText, other methods succeed with true.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []apiRequest
+	messageID int
+	// replies are the responses returned instead of the default ones,
+	// in order, by the method. An empty reply is the default one.
+	replies map[string][]string
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	f := &fakeAPI{replies: make(map[string][]string)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// reply makes the next call of the method answer with the raw response.
+func (f *fakeAPI) reply(method, response string) {
+	f.mu.Lock()
+	f.replies[method] = append(f.replies[method], response)
+	f.mu.Unlock()
+}
+
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, apiRequest{Method: method, Params: r.PostForm})
+	if rr := f.replies[method]; len(rr) != 0 {
+		f.replies[method] = rr[1:]
+		if rr[0] != "" {
+			fmt.Fprint(w, rr[0])
+			return
+		}
+	}
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = tgbotapi.User{ID: 1, FirstName: "Test", UserName: "test_bot"}
+	case "sendMessage", "editMessageText":
+		chatID, _ := strconv.ParseInt(r.PostForm.Get("chat_id"), 10, 64)
+		id, _ := strconv.Atoi(r.PostForm.Get("message_id"))
+		if id == 0 {
+			f.messageID++
+			id = f.messageID
+		}
+		result = tgbotapi.Message{
+			MessageID: id,
+			Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+			Text:      r.PostForm.Get("text"),
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// sent returns the recorded calls of the method.
+func (f *fakeAPI) sent(method string) []apiRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rr []apiRequest
+	for _, r := range f.requests {
+		if r.Method == method {
+			rr = append(rr, r)
+		}
+	}
+	return rr
+}
+
+// rewrite sends the requests to the Bot API to the fake one.
+type rewrite struct {
+	host string
+}
+
+func (rw rewrite) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = rw.host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestStorage opens an empty database in a temporary directory.
+func newTestStorage(t *testing.T) *storage.Storage {
+	dir, err := ioutil.TempDir("", "snbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := storage.New(filepath.Join(dir, "test.db"), log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestBot creates the bot talking to the fake API, the config is
+// completed with the defaults of the test.
+func newTestBot(t *testing.T, cfg *Config) (*SNBot, *fakeAPI, *storage.Storage) {
+	api := newFakeAPI(t)
+	u, _ := url.Parse(api.URL)
+	bot, err := tgbotapi.NewBotAPIWithClient("token", &http.Client{Transport: rewrite{host: u.Host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestStorage(t)
+	cfg.Logger = log.NewNopLogger()
+	cfg.Storage = s
+	cfg.Messenger = &telegram{BotAPI: bot}
+	sn, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sn, api, s
+}
+
+// textMessage is an update with the text sent by the user in the private chat.
+func textMessage(userID int64, text string) tgbotapi.Update {
+	m := &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: int(userID), FirstName: "User", LanguageCode: "en"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		n := len(strings.Fields(text)[0])
+		m.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: n}}
+	}
+	return tgbotapi.Update{Message: m}
+}
@@ -0,0 +1,140 @@
+package snbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Webhook configures receiving updates with an HTTP endpoint instead of long polling.
+type Webhook struct {
+	// URL is the public address Telegram sends updates to,
+	// its path is served by the bot.
+	URL string
+	// Listen is the address of the HTTP server.
+	Listen string
+	// Secret is checked in the X-Telegram-Bot-Api-Secret-Token header.
+	Secret string
+	// CertFile and KeyFile enable TLS, the certificate is uploaded
+	// to Telegram so self-signed ones work as well.
+	CertFile string
+	KeyFile  string
+}
+
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// shutdownTimeout is how long the webhook server waits for the running requests.
+const shutdownTimeout = 10 * time.Second
+
+// updateBuffer is the size of the queue between the webhook and the update loop.
+const updateBuffer = 100
+
+// WebhookHandler returns the handler accepting updates posted by Telegram,
+// the updates are processed by Run. It answers 404 unless the bot is in the
+// webhook mode and 503 when the update is not queued before the request ends.
+func (s *SNBot) WebhookHandler() http.Handler {
+	var secret string
+	if s.cfg.Webhook != nil {
+		secret = s.cfg.Webhook.Secret
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hook == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			level.Warn(s.cfg.Logger).Log("msg", "webhook request with wrong secret", "remote", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var u tgbotapi.Update
+		err := json.NewDecoder(r.Body).Decode(&u)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed decode update", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		select {
+		case s.hook <- u:
+		case <-r.Context().Done():
+			level.Warn(s.cfg.Logger).Log("msg", "update dropped, the queue is full", "update", u.UpdateID)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// setWebhook registers the webhook, removing it switches the bot back to long polling.
+func (s *SNBot) setWebhook() error {
+	wh := s.cfg.Webhook
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return fmt.Errorf("failed parse webhook url: %v", err)
+	}
+	if wh.CertFile == "" {
+		v := url.Values{}
+		v.Add("url", u.String())
+		if wh.Secret != "" {
+			v.Add("secret_token", wh.Secret)
+		}
+		_, err = s.bot.Request("setWebhook", v)
+		return err
+	}
+	params := map[string]string{"url": u.String()}
+	if wh.Secret != "" {
+		params["secret_token"] = wh.Secret
+	}
+	_, err = s.bot.Upload("setWebhook", params, "certificate", wh.CertFile)
+	return err
+}
+
+// serveWebhook runs the HTTP server receiving updates until the context is done.
+func (s *SNBot) serveWebhook(ctx context.Context) error {
+	wh := s.cfg.Webhook
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return fmt.Errorf("failed parse webhook url: %v", err)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, s.WebhookHandler())
+	srv := &http.Server{Addr: wh.Listen, Handler: mux}
+	// stopped is closed once the running requests are finished.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			level.Error(s.cfg.Logger).Log("msg", "failed shutdown webhook server", "err", err)
+		}
+	}()
+	level.Info(s.cfg.Logger).Log("msg", "serve webhook", "addr", wh.Listen, "path", path)
+	if wh.CertFile != "" {
+		err = srv.ListenAndServeTLS(wh.CertFile, wh.KeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		// the server is closed as soon as the shutdown starts.
+		<-stopped
+		return nil
+	}
+	return err
+}
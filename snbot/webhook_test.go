@@ -0,0 +1,161 @@
+package snbot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func newWebhookBot(t *testing.T) (*SNBot, *fakeAPI) {
+	sn, api, _ := newTestBot(t, &Config{Webhook: &Webhook{
+		URL:    "https://example.com/hook",
+		Listen: "127.0.0.1:0",
+		Secret: "secret",
+	}})
+	return sn, api
+}
+
+func postUpdate(ctx context.Context, h http.Handler, body []byte, secret string) int {
+	r := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewReader(body)).WithContext(ctx)
+	r.Header.Set(secretHeader, secret)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestWebhookHandler(t *testing.T) {
+	sn, api := newWebhookBot(t)
+	if rr := api.sent("setWebhook"); len(rr) != 1 || rr[0].Params.Get("secret_token") != "secret" {
+		t.Fatalf("setWebhook requests = %v", rr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sn.Run(ctx)
+	}()
+	files, err := filepath.Glob("testdata/updates/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no fixtures: %v", err)
+	}
+	h := sn.WebhookHandler()
+	for _, name := range files {
+		body, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := postUpdate(context.Background(), h, body, "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("%s with wrong secret: status %d, want %d", name, code, http.StatusUnauthorized)
+		}
+		if code := postUpdate(context.Background(), h, body, "secret"); code != http.StatusOK {
+			t.Errorf("%s: status %d, want %d", name, code, http.StatusOK)
+		}
+	}
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	// start.json and help.json are answered, callback.json is acknowledged.
+	var chats []string
+	for _, r := range api.sent("sendMessage") {
+		chats = append(chats, r.Params.Get("chat_id"))
+	}
+	sort.Strings(chats)
+	if len(chats) != 2 || chats[0] != "100" || chats[1] != "200" {
+		t.Errorf("sendMessage chats = %v, want [100 200]", chats)
+	}
+	if rr := api.sent("answerCallbackQuery"); len(rr) != 1 || rr[0].Params.Get("callback_query_id") != "q1" {
+		t.Errorf("answerCallbackQuery requests = %v", rr)
+	}
+}
+
+func TestWebhookHandlerNotWebhook(t *testing.T) {
+	sn, _, _ := newTestBot(t, &Config{SendOnly: true})
+	code := postUpdate(context.Background(), sn.WebhookHandler(), []byte(`{"update_id":1}`), "")
+	if code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestWebhookHandlerCanceled(t *testing.T) {
+	sn, _ := newWebhookBot(t)
+	// nobody reads the queue.
+	sn.hook = make(chan tgbotapi.Update)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code := postUpdate(ctx, sn.WebhookHandler(), []byte(`{"update_id":1}`), "secret")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestWebhookShutdownInFlight(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	sn, api, _ := newTestBot(t, &Config{Webhook: &Webhook{URL: "https://example.com/hook", Listen: addr}})
+	body, err := ioutil.ReadFile("testdata/updates/start.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sn.Run(ctx)
+	}()
+	for i := 0; ; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the request is in flight while the bot stops.
+	pr, pw := io.Pipe()
+	code := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+addr+"/hook", "application/json", pr)
+		if err != nil {
+			code <- 0
+			return
+		}
+		resp.Body.Close()
+		code <- resp.StatusCode
+	}()
+	pw.Write(body[:10])
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	pw.Write(body[10:])
+	pw.Close()
+
+	if c := <-code; c != http.StatusOK {
+		t.Fatalf("status %d, want %d", c, http.StatusOK)
+	}
+	err = <-done
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if rr := api.sent("sendMessage"); len(rr) != 1 {
+		t.Errorf("sendMessage requests = %v, want the update answered before Run returns", rr)
+	}
+}
@@ -3,9 +3,13 @@ docker stop xfreehack
 docker rm xfreehack
 docker run \
   --restart=always \
+  --stop-timeout=30 \
   --name xfreehack \
   -e TELEGRAM_TOKEN=$TELEGRAM_TOKEN \
+  -e ADMINS=$ADMINS \
   -e PATH_DB=/db/xfree.db \
+  -e API_KEYS=$API_KEYS \
+  -p 8080:8080 \
   -v /db/:/db \
   -d xfreehack:latest
 sleep 0.1
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// connector opens the database connections observing the query durations.
+type connector struct {
+	dsn      string
+	driver   driver.Driver
+	duration metrics.Histogram
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	cn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn, duration: c.duration}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn observes the queries executed on the connection by the statement
+// kind, the reading of the rows is not counted.
+type conn struct {
+	driver.Conn
+	duration metrics.Histogram
+}
+
+func (c *conn) observe(query string, begin time.Time) {
+	op := "unknown"
+	if ff := strings.Fields(query); len(ff) != 0 {
+		op = strings.ToLower(ff[0])
+	}
+	c.duration.With("op", op).Observe(time.Since(begin).Seconds())
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer c.observe(query, time.Now())
+	return ec.ExecContext(ctx, query, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer c.observe(query, time.Now())
+	return qc.QueryContext(ctx, query, args)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return pc.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+// Ping checks the connection is alive, without it the database/sql package
+// assumes it always is.
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+type pingConn struct {
+	driver.Conn
+	err error
+}
+
+func (c pingConn) Ping(context.Context) error {
+	return c.err
+}
+
+func TestConnPing(t *testing.T) {
+	c := &conn{Conn: pingConn{err: driver.ErrBadConn}, duration: discard.NewHistogram()}
+	var _ driver.Pinger = c
+	err := c.Ping(context.Background())
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("Ping() = %v, want %v", err, driver.ErrBadConn)
+	}
+}
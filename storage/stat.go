@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wenkaler/xfreehack/model"
+)
+
+// LogEvent stores the chat's interaction with the coupon.
+func (s *Storage) LogEvent(cid int64, id string, kind string) error {
+	_, err := s.db.Exec(`INSERT INTO events(id_chat, id_record, kind, created) VALUES(?, ?, ?, ?)`, cid, id, kind, time.Now().Unix())
+	return err
+}
+
+// LogSearch stores the search query.
+func (s *Storage) LogSearch(uid int64, query string) error {
+	_, err := s.db.Exec(`INSERT INTO search_queries(user_id, query, created) VALUES(?, ?, ?)`, uid, query, time.Now().Unix())
+	return err
+}
+
+// GetStats computes the analytics for the last days.
+func (s *Storage) GetStats(days int) (model.Stats, error) {
+	var (
+		st    model.Stats
+		since = time.Now().AddDate(0, 0, -days+1)
+		from  = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.Local).Unix()
+	)
+	err := s.db.Get(&st.ActiveChats, `SELECT count(id) FROM chats WHERE active = true`)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Select(&st.Days, `WITH RECURSIVE d(day) AS (
+									SELECT date(?, 'unixepoch', 'localtime')
+									UNION ALL SELECT date(day, '+1 day') FROM d WHERE day < date('now', 'localtime')
+								)
+								SELECT d.day,
+									(SELECT count(id) FROM chats WHERE created != 0 AND date(created, 'unixepoch', 'localtime') = d.day) AS joined,
+									(SELECT count(id) FROM chats WHERE date(deactivated, 'unixepoch', 'localtime') = d.day) AS left,
+									(SELECT count(id) FROM outbox WHERE status = ? AND date(sent, 'unixepoch', 'localtime') = d.day) AS delivered
+								FROM d ORDER BY d.day`, from, model.OutboxSent)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Select(&st.Sources, `SELECT source AS key, count(id) AS count FROM records WHERE created >= ? GROUP BY source ORDER BY count DESC`, from)
+	if err != nil {
+		return st, err
+	}
+	for _, d := range st.Days {
+		st.Delivered += d.Delivered
+	}
+	err = s.db.Get(&st.Clicks, `SELECT count(id) FROM events WHERE kind = ? AND created >= ?`, model.EventCode, from)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Get(&st.VotesUp, `SELECT count(id) FROM votes WHERE vote > 0 AND updated >= ?`, from)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Get(&st.VotesDown, `SELECT count(id) FROM votes WHERE vote < 0 AND updated >= ?`, from)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Select(&st.TopQueries, `SELECT lower(query) AS key, count(id) AS count FROM search_queries WHERE created >= ? AND query != '' GROUP BY lower(query) ORDER BY count DESC LIMIT 10`, from)
+	if err != nil {
+		return st, err
+	}
+	err = s.db.Select(&st.Cohorts, `SELECT strftime('%Y-%W', created, 'unixepoch', 'localtime') AS week, count(id) AS joined, sum(active = true) AS active
+								FROM chats WHERE created != 0 GROUP BY week ORDER BY week DESC LIMIT 8`)
+	return st, err
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/wenkaler/xfreehack/model"
@@ -11,8 +14,10 @@ import (
 	"github.com/go-kit/kit/log/level"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/wenkaler/xfreehack/collector"
 )
 
@@ -21,30 +26,55 @@ type Storage struct {
 	logger log.Logger
 }
 
-func New(pathDB string, logger log.Logger) (*Storage, error) {
+// New opens the database, queryDuration observes the query durations
+// in seconds by the statement kind and may be nil.
+func New(pathDB string, logger log.Logger, queryDuration metrics.Histogram) (*Storage, error) {
 	if pathDB == "" {
 		return nil, fmt.Errorf("pathDB was empty")
 	}
-	db, err := sqlx.Open("sqlite3", pathDB)
-	if err != nil {
-		return nil, err
+	if queryDuration == nil {
+		queryDuration = discard.NewHistogram()
 	}
+	db := sqlx.NewDb(sql.OpenDB(&connector{
+		dsn:      pathDB,
+		driver:   &sqlite3.SQLiteDriver{},
+		duration: queryDuration,
+	}), "sqlite3")
+	// sqlite allows a single writer, the bot and the broadcast workers
+	// share one connection instead of failing with "database is locked".
+	db.SetMaxOpenConns(1)
 	s := &Storage{
 		db:     db,
 		logger: logger,
 	}
-	err = s.init()
+	err := s.init()
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (s *Storage) Collect(record collector.Record) error {
-	_, err := s.db.Exec(`INSERT INTO records(post_id, link, code, description, date) VALUES(?,?,?,?,?) ON CONFLICT(link) DO NOTHING`, record.PostID, record.Link, record.Code, record.Description, record.Date)
+// Ping checks the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Backup writes a consistent copy of the database to the new file.
+func (s *Storage) Backup(path string) error {
+	_, err := s.db.Exec(`VACUUM INTO ?`, path)
 	return err
 }
 
+// Collect stores the record, reports whether it is a new one.
+func (s *Storage) Collect(record collector.Record) (bool, error) {
+	res, err := s.db.Exec(`INSERT INTO records(post_id, link, code, description, date, source, created) VALUES(?,?,?,?,?,?,?) ON CONFLICT(link) DO NOTHING`, record.PostID, record.Link, record.Code, record.Description, record.Date, record.Source, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n != 0, err
+}
+
 func (s *Storage) LoadCollect() (map[string]collector.Record, error) {
 	var m = make(map[string]collector.Record)
 	var rr []collector.Record
@@ -59,7 +89,7 @@ func (s *Storage) LoadCollect() (map[string]collector.Record, error) {
 }
 
 func (s *Storage) NewChat(chat *tgbotapi.Chat) error {
-	_, err := s.db.Unsafe().Exec(`INSERT INTO chats(id, type, user_name, first_name, last_name, active) VALUES(?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET active = true`, chat.ID, chat.Type, chat.UserName, chat.FirstName, chat.LastName, true)
+	_, err := s.db.Unsafe().Exec(`INSERT INTO chats(id, type, user_name, first_name, last_name, active, created) VALUES(?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET active = true, deactivation_reason = NULL, deactivated = NULL`, chat.ID, chat.Type, chat.UserName, chat.FirstName, chat.LastName, true, time.Now().Unix())
 	return err
 }
 
@@ -71,7 +101,7 @@ func (s *Storage) NewMessage(msg *tgbotapi.Message) error {
 func (s *Storage) GetNotUseCoupon(cid int64) ([]collector.Record, error) {
 	var rr []collector.Record
 	var t = time.Now().AddDate(0, 0, -1).Unix()
-	err := s.db.Unsafe().Select(&rr, `select records.* from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record where rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ? limit 5`, cid, t, t)
+	err := s.db.Unsafe().Select(&rr, `select records.* from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record LEFT OUTER JOIN (SELECT id_record, sum(vote) as score FROM votes GROUP BY id_record) v on records.id = v.id_record where (rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ?) and records.hidden = 0 order by ifnull(v.score, 0) desc, records.id limit 5`, cid, t, t)
 	if err != nil {
 		return nil, err
 	}
@@ -81,16 +111,141 @@ func (s *Storage) GetNotUseCoupon(cid int64) ([]collector.Record, error) {
 func (s *Storage) GetNotUseCouponCount(cid, count int64) ([]collector.Record, error) {
 	var rr []collector.Record
 	var t = time.Now().AddDate(0, 0, -1).Unix()
-	err := s.db.Unsafe().Select(&rr, `select records.* from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record where rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ? limit ?`, cid, t, t, count)
+	err := s.db.Unsafe().Select(&rr, `select records.* from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record LEFT OUTER JOIN (SELECT id_record, sum(vote) as score FROM votes GROUP BY id_record) v on records.id = v.id_record where (rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ?) and records.hidden = 0 order by ifnull(v.score, 0) desc, records.id limit ?`, cid, t, t, count)
 	if err != nil {
 		return nil, err
 	}
 	return rr, nil
 }
 
+func (s *Storage) GetRecord(id string) (collector.Record, error) {
+	var r collector.Record
+	err := s.db.Unsafe().Get(&r, `SELECT * FROM records WHERE id = ?`, id)
+	return r, err
+}
+
+// GetCoupon returns the record unless it is hidden, sql.ErrNoRows
+// is returned for the hidden ones.
+func (s *Storage) GetCoupon(id string) (collector.Record, error) {
+	var r collector.Record
+	err := s.db.Unsafe().Get(&r, `SELECT * FROM records WHERE id = ? AND hidden = 0`, id)
+	return r, err
+}
+
+// Vote stores the chat's feedback about the record and returns
+// the record's total score.
+func (s *Storage) Vote(cid int64, id string, vote int) (int, error) {
+	_, err := s.db.Exec(`INSERT INTO votes(id_record, id_chat, vote, updated) VALUES(?, ?, ?, ?) ON CONFLICT(id_record, id_chat) DO UPDATE SET vote = EXCLUDED.vote, updated = EXCLUDED.updated`, id, cid, vote, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	var score int
+	err = s.db.Get(&score, `SELECT ifnull(sum(vote), 0) FROM votes WHERE id_record = ?`, id)
+	return score, err
+}
+
+// HideRecord excludes the record from the delivery,
+// reports whether the record was visible before.
+func (s *Storage) HideRecord(id string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE records SET hidden = 1 WHERE id = ? AND hidden = 0`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n != 0, err
+}
+
+// SearchCoupons returns active coupons matching the query by code,
+// description or link.
+func (s *Storage) SearchCoupons(query string, limit, offset int) ([]collector.Record, error) {
+	var rr []collector.Record
+	var t = time.Now().AddDate(0, 0, -1).Unix()
+	q := "%" + strings.TrimSpace(query) + "%"
+	err := s.db.Unsafe().Select(&rr, `SELECT records.* FROM records LEFT OUTER JOIN (SELECT id_record, sum(vote) as score FROM votes GROUP BY id_record) v on records.id = v.id_record WHERE records.date > ? AND records.hidden = 0 AND (records.code LIKE ? OR records.description LIKE ? OR records.link LIKE ?) ORDER BY ifnull(v.score, 0) DESC, records.id DESC LIMIT ? OFFSET ?`, t, q, q, q, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// ListCoupons returns not hidden coupons matching the filter, the latest first.
+func (s *Storage) ListCoupons(f model.CouponFilter) ([]collector.Record, error) {
+	var (
+		where = []string{"hidden = 0"}
+		args  []interface{}
+	)
+	if !f.Expired {
+		after := time.Now().AddDate(0, 0, -1).Unix()
+		if f.ExpiresAfter != 0 {
+			after = f.ExpiresAfter
+		}
+		where = append(where, "date > ?")
+		args = append(args, after)
+	}
+	if f.ExpiresBefore != 0 {
+		where = append(where, "date < ?")
+		args = append(args, f.ExpiresBefore)
+	}
+	if f.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, f.Source)
+	}
+	if q := strings.TrimSpace(f.Query); q != "" {
+		q = "%" + q + "%"
+		where = append(where, "(code LIKE ? OR description LIKE ? OR link LIKE ?)")
+		args = append(args, q, q, q)
+	}
+	args = append(args, f.Limit, f.Offset)
+	var rr []collector.Record
+	err := s.db.Unsafe().Select(&rr, `SELECT * FROM records WHERE `+strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// GetNotPosted returns active coupons not yet posted to the channel,
+// coupons skipped by the same filter are not returned.
+func (s *Storage) GetNotPosted(channel, filter string) ([]collector.Record, error) {
+	var rr []collector.Record
+	var t = time.Now().AddDate(0, 0, -1).Unix()
+	err := s.db.Unsafe().Select(&rr, `SELECT records.* FROM records LEFT OUTER JOIN (SELECT * FROM channel_posts WHERE channel = ? AND (skipped = 0 OR filter = ?)) cp ON records.id = cp.id_record WHERE cp.id_record IS NULL AND records.date > ? AND records.hidden = 0 ORDER BY records.id`, channel, filter, t)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// EnqueuePost stores the post of the coupon in the outbox and records
+// that the coupon is posted to the channel.
+func (s *Storage) EnqueuePost(channel string, id string, m model.OutboxMessage) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO channel_posts(channel, id_record) VALUES(?, ?) ON CONFLICT(channel, id_record) DO UPDATE SET skipped = 0, filter = '' WHERE skipped = 1`, channel, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO outbox(id_chat, channel, message, markup, parse_mode, records, status, next_attempt, created) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, m.ChatID, m.Channel, m.Text, m.Markup, m.ParseMode, m.Records, model.OutboxPending, 0, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SkipPost records that the coupon does not match the filter of the
+// channel, it is checked again once the filter is changed.
+func (s *Storage) SkipPost(channel, filter, id string) error {
+	_, err := s.db.Exec(`INSERT INTO channel_posts(channel, id_record, skipped, filter) VALUES(?, ?, 1, ?) ON CONFLICT(channel, id_record) DO UPDATE SET filter = EXCLUDED.filter WHERE skipped = 1`, channel, id, filter)
+	return err
+}
+
+// GetUnsentNotification returns confirmed notifications which time has come.
 func (s *Storage) GetUnsentNotification() ([]model.Notification, error) {
 	var rr []model.Notification
-	err := s.db.Unsafe().Select(&rr, `select * from notification where send = false`)
+	err := s.db.Unsafe().Select(&rr, `select * from notification where send = false and confirmed = true and send_at <= ?`, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -105,10 +260,80 @@ func (s *Storage) MarkSentNotification(id int64) error {
 	return nil
 }
 
+// NewNotification stores a notification draft and returns its id.
+func (s *Storage) NewNotification(n model.Notification) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO notification(message, send, confirmed, send_at, created_by, created) VALUES(?, false, false, ?, ?, ?)`, n.Message, n.SendAt, n.CreatedBy, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ConfirmNotification allows the notification draft to be sent,
+// reports whether the draft existed.
+func (s *Storage) ConfirmNotification(id int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE notification SET confirmed = true WHERE id = ? AND confirmed = false`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n != 0, err
+}
+
+// DeleteNotification removes the notification draft.
+func (s *Storage) DeleteNotification(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM notification WHERE id = ? AND confirmed = false`, id)
+	return err
+}
+
+// EnqueueNotifications puts the due notifications to the outbox of every active chat
+// and returns the number of enqueued notifications.
+func (s *Storage) EnqueueNotifications() (int, error) {
+	nn, err := s.GetUnsentNotification()
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range nn {
+		tx, err := s.db.Beginx()
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT INTO outbox(id_chat, message, status, next_attempt, created, id_notification) SELECT id, ?, ?, 0, ?, ? FROM chats WHERE active = true`, n.Message, model.OutboxPending, time.Now().Unix(), n.ID)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		_, err = tx.Exec(`UPDATE notification SET send = true WHERE id = ?`, n.ID)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(nn), nil
+}
+
+// GetNotificationStats returns the last notifications with their delivery progress.
+func (s *Storage) GetNotificationStats(count int64) ([]model.NotificationStat, error) {
+	var rr []model.NotificationStat
+	err := s.db.Unsafe().Select(&rr, `SELECT n.*,
+       									(SELECT count(id) FROM outbox WHERE id_notification = n.id AND status = ?) AS pending,
+       									(SELECT count(id) FROM outbox WHERE id_notification = n.id AND status = ?) AS delivered,
+       									(SELECT count(id) FROM outbox WHERE id_notification = n.id AND status = ?) AS dead
+									FROM notification n ORDER BY n.id DESC LIMIT ?`, model.OutboxPending, model.OutboxSent, model.OutboxDead, count)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
 func (s *Storage) CountNotUseCoupon(cid int64) (uint64, error) {
 	var rr []uint64
 	var t = time.Now().AddDate(0, 0, -1).Unix()
-	err := s.db.Unsafe().Select(&rr, `select count(records.id) from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record where rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ?`, cid, t, t)
+	err := s.db.Unsafe().Select(&rr, `select count(records.id) from records LEFT OUTER JOIN (SELECT * FROM relation_chat_records as rcr where rcr.id_chat = ?)  rcr on records.id = rcr.id_record where (rcr.status = 0 and records.date = ? or rcr.id_record is null and records.date > ?) and records.hidden = 0`, cid, t, t)
 	if err != nil {
 		return 0, err
 	}
@@ -117,7 +342,7 @@ func (s *Storage) CountNotUseCoupon(cid int64) (uint64, error) {
 
 func (s *Storage) MarkAsRead(cid int64, rr []collector.Record) error {
 	for _, r := range rr {
-		_, err := s.db.Unsafe().Exec(`INSERT INTO relation_chat_records (id_record, id_chat, status) VALUES(?, ?, ?) ON CONFLICT(id_chat, id_record) DO UPDATE SET status = EXCLUDED.status`, r.ID, cid, true)
+		_, err := s.db.Unsafe().Exec(`INSERT INTO relation_chat_records (id_record, id_chat, status, updated) VALUES(?, ?, ?, ?) ON CONFLICT(id_chat, id_record) DO UPDATE SET status = EXCLUDED.status, updated = EXCLUDED.updated WHERE status = ?`, r.ID, cid, model.StatusSent, time.Now().Unix(), model.StatusNew)
 		if err != nil {
 			return err
 		}
@@ -125,22 +350,240 @@ func (s *Storage) MarkAsRead(cid int64, rr []collector.Record) error {
 	return nil
 }
 
+// SetCouponStatus moves the coupon received by the chat to the next status.
+func (s *Storage) SetCouponStatus(cid int64, id string, status model.CouponStatus) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var cur model.CouponStatus
+	err = tx.Get(&cur, `SELECT CAST(status AS INTEGER) FROM relation_chat_records WHERE id_chat = ? AND id_record = ?`, cid, id)
+	if err != nil {
+		return err
+	}
+	if cur == status {
+		return nil
+	}
+	if !cur.CanChange(status) {
+		return model.ErrBadTransition
+	}
+	_, err = tx.Exec(`UPDATE relation_chat_records SET status = ?, updated = ? WHERE id_chat = ? AND id_record = ?`, status, time.Now().Unix(), cid, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetHistory returns the last coupons received by the chat.
+func (s *Storage) GetHistory(cid, count int64) ([]model.HistoryRecord, error) {
+	var rr []model.HistoryRecord
+	err := s.db.Unsafe().Select(&rr, `SELECT records.*, CAST(rcr.status AS INTEGER) AS status, rcr.updated FROM relation_chat_records rcr JOIN records ON records.id = rcr.id_record WHERE rcr.id_chat = ? AND rcr.status != ? ORDER BY rcr.updated DESC, rcr.id DESC LIMIT ?`, cid, model.StatusNew, count)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// GetSaved returns the coupons saved by the chat.
+func (s *Storage) GetSaved(cid int64) ([]collector.Record, error) {
+	var rr []collector.Record
+	err := s.db.Unsafe().Select(&rr, `SELECT records.* FROM relation_chat_records rcr JOIN records ON records.id = rcr.id_record WHERE rcr.id_chat = ? AND rcr.status = ? ORDER BY rcr.updated DESC`, cid, model.StatusSaved)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// Enqueue stores the message in the outbox.
+func (s *Storage) Enqueue(m model.OutboxMessage) error {
+	_, err := s.db.Exec(`INSERT INTO outbox(id_chat, channel, message, markup, parse_mode, records, status, next_attempt, created) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, m.ChatID, m.Channel, m.Text, m.Markup, m.ParseMode, m.Records, model.OutboxPending, 0, time.Now().Unix())
+	return err
+}
+
+// HasPending reports whether the chat has undelivered messages in the outbox.
+func (s *Storage) HasPending(cid int64) (bool, error) {
+	var n int
+	err := s.db.Get(&n, `SELECT count(id) FROM outbox WHERE id_chat = ? AND status = ? AND records != ''`, cid, model.OutboxPending)
+	return n != 0, err
+}
+
+// CountPending returns the number of undelivered messages in the outbox.
+func (s *Storage) CountPending() (int, error) {
+	var n int
+	err := s.db.Get(&n, `SELECT count(id) FROM outbox WHERE status = ?`, model.OutboxPending)
+	return n, err
+}
+
+// GetDueMessages returns pending messages ready for the next attempt,
+// messages of a chat waiting for the retry of an earlier one are held back.
+func (s *Storage) GetDueMessages(limit int) ([]model.OutboxMessage, error) {
+	var (
+		mm  []model.OutboxMessage
+		now = time.Now().Unix()
+	)
+	err := s.db.Unsafe().Select(&mm, `SELECT * FROM outbox o WHERE status = ? AND next_attempt <= ?
+										AND NOT EXISTS (SELECT 1 FROM outbox p WHERE p.id_chat = o.id_chat AND p.channel = o.channel AND p.status = ? AND p.id < o.id AND p.next_attempt > ?)
+										ORDER BY id LIMIT ?`, model.OutboxPending, now, model.OutboxPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return mm, nil
+}
+
+// MarkDelivered marks the message as sent and its coupons as read.
+func (s *Storage) MarkDelivered(m model.OutboxMessage) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	_, err = tx.Exec(`UPDATE outbox SET status = ?, attempts = attempts + 1, sent = ? WHERE id = ?`, model.OutboxSent, now, m.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range strings.Split(m.Records, ",") {
+		if id == "" {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO relation_chat_records (id_record, id_chat, status, updated) VALUES(?, ?, ?, ?) ON CONFLICT(id_chat, id_record) DO UPDATE SET status = EXCLUDED.status, updated = EXCLUDED.updated WHERE status = ?`, id, m.ChatID, model.StatusSent, now, model.StatusNew)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RetryMessage schedules the next delivery attempt of the message
+// keeping its chat and the number of its delivered chunks.
+func (s *Storage) RetryMessage(m model.OutboxMessage, next time.Time, reason string) error {
+	_, err := s.db.Exec(`UPDATE outbox SET id_chat = ?, attempts = attempts + 1, next_attempt = ?, last_error = ?, chunks = ? WHERE id = ?`, m.ChatID, next.Unix(), reason, m.Chunks, m.ID)
+	return err
+}
+
+// MarkDead stops delivery attempts of the message.
+func (s *Storage) MarkDead(id int64, reason string) error {
+	_, err := s.db.Exec(`UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?`, model.OutboxDead, reason, id)
+	return err
+}
+
+// GetRole returns the role of the user, RoleNone for non admins.
+func (s *Storage) GetRole(uid int64) (model.Role, error) {
+	var rr []model.Role
+	err := s.db.Select(&rr, `SELECT role FROM admins WHERE user_id = ?`, uid)
+	if err != nil || len(rr) == 0 {
+		return model.RoleNone, err
+	}
+	return rr[0], nil
+}
+
+// ListChats returns the chats, only the active ones with onlyActive.
+func (s *Storage) ListChats(onlyActive bool, limit, offset int) ([]model.Chat, error) {
+	var cc []model.Chat
+	err := s.db.Select(&cc, `SELECT id, type, ifnull(user_name, '') user_name, ifnull(first_name, '') first_name, ifnull(last_name, '') last_name, active, ifnull(deactivation_reason, '') deactivation_reason, ifnull(created, 0) created, ifnull(deactivated, 0) deactivated FROM chats WHERE active = true OR ? = false ORDER BY id LIMIT ? OFFSET ?`, onlyActive, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// GetLanguage returns the language chosen for the chat, empty when not set.
+func (s *Storage) GetLanguage(cid int64) (string, error) {
+	var ll []string
+	err := s.db.Select(&ll, `SELECT language FROM chat_settings WHERE id_chat = ?`, cid)
+	if err != nil || len(ll) == 0 {
+		return "", err
+	}
+	return ll[0], nil
+}
+
+// SetLanguage stores the language of the chat, empty language resets it.
+func (s *Storage) SetLanguage(cid int64, lang string) error {
+	_, err := s.db.Exec(`INSERT INTO chat_settings(id_chat, language) VALUES(?, ?) ON CONFLICT(id_chat) DO UPDATE SET language = excluded.language`, cid, lang)
+	return err
+}
+
+// GetAdmins returns the admins with at least the given role.
+func (s *Storage) GetAdmins(role model.Role) ([]model.Admin, error) {
+	var aa []model.Admin
+	err := s.db.Unsafe().Select(&aa, `SELECT * FROM admins WHERE role >= ? ORDER BY role DESC, user_id`, role)
+	if err != nil {
+		return nil, err
+	}
+	return aa, nil
+}
+
+// SetAdmin creates the admin or changes its role.
+func (s *Storage) SetAdmin(a model.Admin) error {
+	_, err := s.db.Exec(`INSERT INTO admins(user_id, role, added_by, created) VALUES(?, ?, ?, ?) ON CONFLICT(user_id) DO UPDATE SET role = EXCLUDED.role, added_by = EXCLUDED.added_by`, a.UserID, a.Role, a.AddedBy, time.Now().Unix())
+	return err
+}
+
+// RemoveAdmin revokes all the roles of the user.
+func (s *Storage) RemoveAdmin(uid int64) error {
+	_, err := s.db.Exec(`DELETE FROM admins WHERE user_id = ?`, uid)
+	return err
+}
+
+// Audit stores the admin action.
+func (s *Storage) Audit(e model.AuditEntry) error {
+	_, err := s.db.Exec(`INSERT INTO admin_audit(user_id, id_chat, actor, action, args, allowed, created) VALUES(?, ?, ?, ?, ?, ?, ?)`, e.UserID, e.ChatID, e.Actor, e.Action, e.Args, e.Allowed, time.Now().Unix())
+	return err
+}
+
 func (s *Storage) GetChat() (a []int64, err error) {
 	err = s.db.Unsafe().Select(&a, `SELECT id FROM chats WHERE active = 1`)
 	return
 }
 
 func (s *Storage) GetCountUser() (int, error) {
-	var a []int
-	err := s.db.Unsafe().Select(&a, `SELECT count(id) FROM chats WHERE active = 1`)
-	return a[0], err
+	var n int
+	err := s.db.Get(&n, `SELECT count(id) FROM chats WHERE active = 1`)
+	return n, err
 }
 
-func (s *Storage) UpdChatActivity(cid int64, act bool) error {
-	_, err := s.db.Unsafe().Exec(`UPDATE chats SET active = ? where id = ?`, act, cid)
+// DeactivateChat stops deliveries to the chat, storing the reason.
+func (s *Storage) DeactivateChat(cid int64, reason string) error {
+	_, err := s.db.Exec(`UPDATE chats SET active = false, deactivation_reason = ?, deactivated = ? where id = ? and active = true`, reason, time.Now().Unix(), cid)
 	return err
 }
 
+// MigrateChat moves the chat and its history to the new chat id,
+// used when a group is upgraded to a supergroup.
+func (s *Storage) MigrateChat(from, to int64) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`UPDATE OR IGNORE chats SET id = ?, type = 'supergroup' WHERE id = ?`,
+		`UPDATE OR IGNORE relation_chat_records SET id_chat = ? WHERE id_chat = ?`,
+		`UPDATE OR IGNORE votes SET id_chat = ? WHERE id_chat = ?`,
+		`UPDATE messages SET id_chat = ? WHERE id_chat = ?`,
+		`UPDATE outbox SET id_chat = ? WHERE id_chat = ?`,
+		`UPDATE OR IGNORE chat_settings SET id_chat = ? WHERE id_chat = ?`,
+	} {
+		_, err = tx.Exec(q, to, from)
+		if err != nil {
+			return err
+		}
+	}
+	for _, q := range []string{
+		`DELETE FROM relation_chat_records WHERE id_chat = ?`,
+		`DELETE FROM votes WHERE id_chat = ?`,
+		`DELETE FROM chats WHERE id = ?`,
+		`DELETE FROM chat_settings WHERE id_chat = ?`,
+	} {
+		_, err = tx.Exec(q, from)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
@@ -168,6 +611,40 @@ func (s *Storage) init() error {
 	if err != nil {
 		return fmt.Errorf("failed create chats table: %v", err)
 	}
+	for _, c := range []struct{ table, name, definition string }{
+		{"chats", "deactivation_reason", "TEXT NULL"},
+		{"chats", "created", "BIGINT NOT NULL DEFAULT 0"},
+		{"chats", "deactivated", "BIGINT NULL"},
+		{"records", "source", "VARCHAR(100) NOT NULL DEFAULT ''"},
+		{"records", "created", "BIGINT NOT NULL DEFAULT 0"},
+	} {
+		err = s.addColumn(c.table, c.name, c.definition)
+		if err != nil {
+			return fmt.Errorf("failed add %s column: %v", c.name, err)
+		}
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS events(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									id_chat INTEGER NOT NULL,
+									id_record INTEGER NULL,
+									kind VARCHAR(40) NOT NULL,
+									created BIGINT NOT NULL
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create events table: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS search_queries(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									user_id INTEGER NOT NULL,
+									query VARCHAR(256) NOT NULL,
+									created BIGINT NOT NULL
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create search_queries table: %v", err)
+	}
+
 	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS messages(
 									id INTEGER PRIMARY KEY UNIQUE,
 									id_chat INTEGER NOT NULL,
@@ -194,6 +671,72 @@ func (s *Storage) init() error {
 		return fmt.Errorf("failed create index table: %v", err)
 	}
 
+	err = s.addColumn("relation_chat_records", "updated", "BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("failed add updated column: %v", err)
+	}
+
+	err = s.addColumn("records", "hidden", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("failed add hidden column: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS votes(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									id_record INTEGER NOT NULL,
+									id_chat INTEGER NOT NULL,
+									vote INTEGER NOT NULL,
+									UNIQUE (id_record, id_chat),
+									FOREIGN KEY (id_chat) REFERENCES chats(id),
+									FOREIGN KEY (id_record) REFERENCES records(id)
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create votes table: %v", err)
+	}
+
+	err = s.addColumn("votes", "updated", "BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("failed add updated column: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS channel_posts(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									channel VARCHAR(100) NOT NULL,
+									id_record INTEGER NOT NULL,
+									UNIQUE (channel, id_record),
+									FOREIGN KEY (id_record) REFERENCES records(id)
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create channel_posts table: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS outbox(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									id_chat INTEGER NOT NULL,
+									message TEXT NOT NULL,
+									markup TEXT NOT NULL DEFAULT '',
+									records TEXT NOT NULL DEFAULT '',
+									status INTEGER NOT NULL DEFAULT 0,
+									attempts INTEGER NOT NULL DEFAULT 0,
+									next_attempt BIGINT NOT NULL DEFAULT 0,
+									last_error TEXT NOT NULL DEFAULT '',
+									created BIGINT NOT NULL,
+									sent BIGINT NULL,
+									FOREIGN KEY (id_chat) REFERENCES chats(id)
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create outbox table: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS outbox_status ON outbox(status, next_attempt)`)
+	if err != nil {
+		return fmt.Errorf("failed create index table: %v", err)
+	}
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS outbox_chat ON outbox(id_chat, status)`)
+	if err != nil {
+		return fmt.Errorf("failed create index table: %v", err)
+	}
+
 	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS notification(
 									id INTEGER PRIMARY KEY AUTOINCREMENT,
 									message TEXT NOT NULL,
@@ -202,6 +745,101 @@ func (s *Storage) init() error {
 	if err != nil {
 		return fmt.Errorf("failed create messages table: %v", err)
 	}
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS admins(
+									user_id INTEGER PRIMARY KEY,
+									role INTEGER NOT NULL,
+									added_by INTEGER NOT NULL DEFAULT 0,
+									created BIGINT NOT NULL
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create admins table: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS admin_audit(
+									id INTEGER PRIMARY KEY AUTOINCREMENT,
+									user_id INTEGER NOT NULL,
+									id_chat INTEGER NOT NULL,
+									action VARCHAR(100) NOT NULL,
+									args TEXT NOT NULL,
+									allowed BOOLEAN NOT NULL,
+									created BIGINT NOT NULL
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create admin_audit table: %v", err)
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS chat_settings(
+									id_chat INTEGER PRIMARY KEY,
+									language VARCHAR(10) NOT NULL DEFAULT ''
+						)`)
+	if err != nil {
+		return fmt.Errorf("failed create chat_settings table: %v", err)
+	}
+
+	// notifications stored before the confirmation existed are drafts
+	// unless they were sent already, nothing goes out unconfirmed.
+	for _, c := range []struct{ name, definition string }{
+		{"confirmed", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"send_at", "BIGINT NOT NULL DEFAULT 0"},
+		{"created_by", "INTEGER NOT NULL DEFAULT 0"},
+		{"created", "BIGINT NOT NULL DEFAULT 0"},
+	} {
+		err = s.addColumn("notification", c.name, c.definition)
+		if err != nil {
+			return fmt.Errorf("failed add %s column: %v", c.name, err)
+		}
+	}
+	_, err = s.db.Exec(`UPDATE notification SET confirmed = true WHERE send = true AND confirmed = false`)
+	if err != nil {
+		return fmt.Errorf("failed confirm sent notifications: %v", err)
+	}
+
+	err = s.addColumn("outbox", "id_notification", "INTEGER NULL REFERENCES notification(id)")
+	if err != nil {
+		return fmt.Errorf("failed add id_notification column: %v", err)
+	}
+	err = s.addColumn("outbox", "parse_mode", "VARCHAR(20) NOT NULL DEFAULT ''")
+	if err != nil {
+		return fmt.Errorf("failed add parse_mode column: %v", err)
+	}
+	err = s.addColumn("outbox", "chunks", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("failed add chunks column: %v", err)
+	}
+	err = s.addColumn("outbox", "channel", "VARCHAR(100) NOT NULL DEFAULT ''")
+	if err != nil {
+		return fmt.Errorf("failed add channel column: %v", err)
+	}
+	err = s.addColumn("channel_posts", "skipped", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("failed add skipped column: %v", err)
+	}
+	err = s.addColumn("channel_posts", "filter", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return fmt.Errorf("failed add filter column: %v", err)
+	}
+	err = s.addColumn("admin_audit", "actor", "VARCHAR(50) NOT NULL DEFAULT ''")
+	if err != nil {
+		return fmt.Errorf("failed add actor column: %v", err)
+	}
 	level.Info(s.logger).Log("msg", "create data base, with table.")
 	return nil
 }
+
+// addColumn adds the column to the existing table unless it is already there.
+func (s *Storage) addColumn(table, column, definition string) error {
+	var cols []struct {
+		Name string `db:"name"`
+	}
+	err := s.db.Unsafe().Select(&cols, fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if c.Name == column {
+			return nil
+		}
+	}
+	_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
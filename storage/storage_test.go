@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wenkaler/xfreehack/model"
+
+	"github.com/go-kit/kit/log"
+)
+
+func tempDB(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "test.db")
+}
+
+func TestMigrateNotifications(t *testing.T) {
+	path := tempDB(t)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the table as it was before the confirmation of announcements.
+	for _, q := range []string{
+		`CREATE TABLE notification(id INTEGER PRIMARY KEY AUTOINCREMENT, message TEXT NOT NULL, send BOOLEAN DEFAULT FALSE)`,
+		`INSERT INTO notification(id, message, send) VALUES(1, 'sent', true), (2, 'unsent', false)`,
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := New(path, log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	nn, err := s.GetUnsentNotification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nn) != 0 {
+		t.Errorf("due notifications = %+v, want none", nn)
+	}
+	for id, want := range map[int64]bool{1: true, 2: false} {
+		var confirmed bool
+		err = s.db.Get(&confirmed, `SELECT confirmed FROM notification WHERE id = ?`, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if confirmed != want {
+			t.Errorf("notification %d confirmed = %t, want %t", id, confirmed, want)
+		}
+	}
+	// the old draft can still be confirmed.
+	ok, err := s.ConfirmNotification(2)
+	if err != nil || !ok {
+		t.Errorf("ConfirmNotification() = %t, %v, want true", ok, err)
+	}
+}
+
+func TestStatsDelivered(t *testing.T) {
+	s, err := New(tempDB(t), log.NewNopLogger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, text := range []string{"delivered", "pending"} {
+		err = s.Enqueue(model.OutboxMessage{ChatID: 1, Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mm, err := s.GetDueMessages(1)
+	if err != nil || len(mm) != 1 {
+		t.Fatalf("GetDueMessages() = %+v, %v, want a message", mm, err)
+	}
+	err = s.MarkDelivered(mm[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a coupon used today was delivered earlier.
+	_, err = s.db.Exec(`INSERT INTO relation_chat_records (id_record, id_chat, status, updated) VALUES(1, 1, ?, ?)`, model.StatusUsed, time.Now().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.GetStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Days) != 1 || st.Days[0].Delivered != 1 || st.Delivered != 1 {
+		t.Errorf("stats = %+v, want one message delivered today", st)
+	}
+}